@@ -1,8 +1,8 @@
 package bumble
 
 import (
-	"encoding/json"
 	"net/http"
+	"reflect"
 	"strings"
 	"time"
 
@@ -75,6 +75,19 @@ func (u *User) AllPhotos() []*Photo {
 	return res
 }
 
+// SameProfile checks if two users are identical, ignoring
+// the fields which change every time a profile is seen,
+// such as ScanDate and the distance to the scanner.
+func (u *User) SameProfile(other *User) bool {
+	u1, u2 := *u, *other
+	for _, x := range []*User{&u1, &u2} {
+		x.ScanDate = time.Time{}
+		x.DistanceLong = ""
+		x.DistanceShort = ""
+	}
+	return reflect.DeepEqual(u1, u2)
+}
+
 // SetLocation sets the Location field based on the
 // ProfileFields. This should only be necessary for legacy
 // purposes, or when downloading new users and populating
@@ -112,133 +125,13 @@ func (b *BumbleAPI) GetEncounters() ([]*User, error) {
 	}
 	defer resp.Body.Close()
 
-	var responseObj struct {
-		Body []struct {
-			ServerErrorMessage *struct {
-				ErrorMessage string `json:"error_message"`
-			} `json:"server_error_message"`
-
-			ClientEncounters struct {
-				Results []struct {
-					User struct {
-						UserID        string `json:"user_id"`
-						Name          string `json:"name"`
-						Age           int    `json:"age"`
-						Gender        int    `json:"gender"`
-						Verified      bool   `json:"is_verified"`
-						DistanceLong  string `json:"distance_long"`
-						DistanceShort string `json:"distance_short"`
-						Albums        []struct {
-							UID     string `json:"uid"`
-							Name    string `json:"name"`
-							Caption string `json:"caption"`
-							Photos  []struct {
-								ID             string `json:"id"`
-								PreviewURL     string `json:"preview_url"`
-								LargeURL       string `json:"large_url"`
-								LargePhotoSize struct {
-									Width  int `json:"width"`
-									Height int `json:"height"`
-								} `json:"large_photo_size"`
-								FaceTopLeft struct {
-									X int `json:"x"`
-									Y int `json:"y"`
-								} `json:"face_top_left"`
-								FaceBottomRight struct {
-									X int `json:"x"`
-									Y int `json:"y"`
-								} `json:"face_bottom_right"`
-							} `json:"photos"`
-						} `json:"albums"`
-						MusicServices []struct {
-							Status           int `json:"status"`
-							ExternalProvider struct {
-								ID          string `json:"id"`
-								DisplayName string `json:"display_name"`
-								Type        int    `json:"type"`
-							} `json:"external_provider"`
-							TopArtists []struct {
-								ID   string `json:"id"`
-								Name string `json:"name"`
-							} `json:"top_artists"`
-						} `json:"music_services"`
-						ProfileFields []struct {
-							ID           string `json:"id"`
-							Type         int    `json:"type"`
-							Name         string `json:"name"`
-							DisplayValue string `json:"display_value"`
-						} `json:"profile_fields"`
-					} `json:"user"`
-				} `json:"results"`
-			} `json:"client_encounters"`
-		} `json:"body"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&responseObj); err != nil {
+	users, err := ParseEncounters(resp.Body)
+	if err != nil {
 		return nil, errors.Wrap(err, "get encounters")
 	}
-
-	var users []*User
-	for _, body := range responseObj.Body {
-		if body.ServerErrorMessage != nil {
-			return nil, errors.New(body.ServerErrorMessage.ErrorMessage)
-		}
-		for _, result := range body.ClientEncounters.Results {
-			rawUser := result.User
-			user := User{
-				ID:            rawUser.UserID,
-				Name:          rawUser.Name,
-				Age:           rawUser.Age,
-				Gender:        rawUser.Gender,
-				Verified:      rawUser.Verified,
-				DistanceLong:  rawUser.DistanceLong,
-				DistanceShort: rawUser.DistanceShort,
-				ScanDate:      time.Now(),
-			}
-			for _, rawAlbum := range rawUser.Albums {
-				album := &Album{
-					UID:     rawAlbum.UID,
-					Name:    rawAlbum.Name,
-					Caption: rawAlbum.Caption,
-				}
-				for _, rawPhoto := range rawAlbum.Photos {
-					album.Photos = append(album.Photos, &Photo{
-						ID:              rawPhoto.ID,
-						PreviewURL:      rawPhoto.PreviewURL,
-						LargeURL:        rawPhoto.LargeURL,
-						FaceTopLeft:     [2]int{rawPhoto.FaceTopLeft.X, rawPhoto.FaceTopLeft.Y},
-						FaceBottomRight: [2]int{rawPhoto.FaceBottomRight.X, rawPhoto.FaceBottomRight.Y},
-						Width:           rawPhoto.LargePhotoSize.Width,
-						Height:          rawPhoto.LargePhotoSize.Height,
-					})
-				}
-				user.Albums = append(user.Albums, album)
-			}
-			for _, rawMusicService := range rawUser.MusicServices {
-				musicService := &MusicService{
-					ID:          rawMusicService.ExternalProvider.ID,
-					DisplayName: rawMusicService.ExternalProvider.DisplayName,
-					Type:        rawMusicService.ExternalProvider.Type,
-				}
-				for _, rawArtist := range rawMusicService.TopArtists {
-					musicService.TopArtists = append(musicService.TopArtists, &MusicArtist{
-						ID:   rawArtist.ID,
-						Name: rawArtist.Name,
-					})
-				}
-				user.MusicServices = append(user.MusicServices, musicService)
-			}
-			for _, rawProfileField := range rawUser.ProfileFields {
-				user.ProfileFields = append(user.ProfileFields, &ProfileField{
-					ID:           rawProfileField.ID,
-					Type:         rawProfileField.Type,
-					Name:         rawProfileField.Name,
-					DisplayValue: rawProfileField.DisplayValue,
-				})
-			}
-			user.SetLocation()
-			users = append(users, &user)
-		}
+	scanDate := time.Now()
+	for _, user := range users {
+		user.ScanDate = scanDate
 	}
 	return users, nil
 }
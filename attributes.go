@@ -0,0 +1,162 @@
+package bumble
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Values of the categorical ProfileAttributes, in the
+// form that Bumble displays them.
+var (
+	ZodiacSigns = []string{
+		"Aries", "Taurus", "Gemini", "Cancer", "Leo", "Virgo",
+		"Libra", "Scorpio", "Sagittarius", "Capricorn", "Aquarius", "Pisces",
+	}
+	EducationLevels = []string{
+		"High school", "Trade/tech school", "In college", "Undergraduate degree",
+		"In grad school", "Graduate degree",
+	}
+	DrinkingValues = []string{"Frequently", "Socially", "Rarely", "Never", "Sober"}
+	SmokingValues  = []string{"Regularly", "Socially", "Trying to quit", "Never"}
+	ExerciseValues = []string{"Active", "Sometimes", "Almost never"}
+	KidsValues     = []string{
+		"Want someday", "Don't want", "Have & want more", "Have & don't want more",
+		"Not sure yet",
+	}
+	ReligionValues = []string{
+		"Agnostic", "Atheist", "Buddhist", "Catholic", "Christian", "Hindu", "Jain",
+		"Jewish", "Mormon", "Latter-day Saint", "Muslim", "Zoroastrian", "Sikh",
+		"Spiritual", "Other",
+	}
+	PoliticsValues = []string{"Apolitical", "Moderate", "Liberal", "Conservative"}
+)
+
+// ProfileAttributes contains the attributes that a user
+// lists on their profile, decoded from ProfileFields.
+//
+// Each attribute is empty (or zero) if the user does not
+// list it. Categorical attributes are one of the values
+// in the corresponding list, such as ZodiacSigns.
+type ProfileAttributes struct {
+	// HeightCM is the user's height in centimetres, which
+	// Bumble displays in either metric or imperial units.
+	HeightCM int
+
+	Zodiac    string
+	Education string
+	Drinking  string
+	Smoking   string
+	Exercise  string
+	Kids      string
+	Religion  string
+	Politics  string
+
+	// Unknown maps the IDs of attribute fields that could
+	// not be decoded to their display values. This includes
+	// known fields with an unexpected value, and lifestyle
+	// fields that are not decoded at all.
+	Unknown map[string]string
+}
+
+// Attributes decodes the user's attributes from their
+// ProfileFields.
+func (u *User) Attributes() *ProfileAttributes {
+	res := &ProfileAttributes{}
+	for _, field := range u.ProfileFields {
+		if !res.decodeField(field) && strings.HasPrefix(field.ID, "lifestyle_") {
+			if res.Unknown == nil {
+				res.Unknown = map[string]string{}
+			}
+			res.Unknown[field.ID] = field.DisplayValue
+		}
+	}
+	return res
+}
+
+// decodeField sets the attribute for a field, returning
+// false if the field or its value is unknown.
+func (p *ProfileAttributes) decodeField(field *ProfileField) bool {
+	if field.ID == "lifestyle_height" {
+		height, ok := parseHeight(field.DisplayValue)
+		p.HeightCM = height
+		return ok
+	}
+	var dst *string
+	var values []string
+	switch field.ID {
+	case "lifestyle_zodiak":
+		dst, values = &p.Zodiac, ZodiacSigns
+	case "lifestyle_education":
+		dst, values = &p.Education, EducationLevels
+	case "lifestyle_drinking":
+		dst, values = &p.Drinking, DrinkingValues
+	case "lifestyle_smoking":
+		dst, values = &p.Smoking, SmokingValues
+	case "lifestyle_exercise":
+		dst, values = &p.Exercise, ExerciseValues
+	case "lifestyle_kids":
+		dst, values = &p.Kids, KidsValues
+	case "lifestyle_religion":
+		dst, values = &p.Religion, ReligionValues
+	case "lifestyle_politics":
+		dst, values = &p.Politics, PoliticsValues
+	default:
+		return false
+	}
+	value := normalizeAttribute(field.DisplayValue)
+	for _, x := range values {
+		if normalizeAttribute(x) == value {
+			*dst = x
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAttribute removes differences in the way that
+// the same value may be displayed, such as case and the
+// style of apostrophes.
+func normalizeAttribute(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	s = strings.Replace(s, "’", "'", -1)
+	return strings.Replace(s, " and ", " & ", -1)
+}
+
+var (
+	heightCMExpr       = regexp.MustCompile(`(\d+)\s*cm\b`)
+	heightMetersExpr   = regexp.MustCompile(`^(\d)[.,](\d\d)\s*m$`)
+	heightImperialExpr = regexp.MustCompile(`^(\d)\s*(?:'|′|ft)\s*` +
+		`(?:(\d{1,2})\s*(?:"|″|''|in)?)?$`)
+)
+
+// parseHeight parses a displayed height, such as
+// `5' 7" (170 cm)` or "170 cm", into centimetres.
+//
+// When both units are displayed, the metric height is
+// used, since it is not rounded to the nearest inch.
+func parseHeight(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if match := heightCMExpr.FindStringSubmatch(s); match != nil {
+		cm, err := strconv.Atoi(match[1])
+		return cm, err == nil && cm > 0
+	}
+	if match := heightMetersExpr.FindStringSubmatch(s); match != nil {
+		cm, _ := strconv.Atoi(match[1] + match[2])
+		return cm, cm > 0
+	}
+	if match := heightImperialExpr.FindStringSubmatch(s); match != nil {
+		feet, _ := strconv.Atoi(match[1])
+		inches := 0
+		if match[2] != "" {
+			inches, _ = strconv.Atoi(match[2])
+		}
+		if inches >= 12 {
+			return 0, false
+		}
+		cm := int(math.Round(float64(feet*12+inches) * 2.54))
+		return cm, cm > 0
+	}
+	return 0, false
+}
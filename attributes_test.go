@@ -0,0 +1,112 @@
+package bumble
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeight(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected int
+		ok       bool
+	}{
+		{`5' 7" (170 cm)`, 170, true},
+		{`6' 0" (183 cm)`, 183, true},
+		{"170 cm", 170, true},
+		{"183cm", 183, true},
+		{"1.83 m", 183, true},
+		{`5' 7"`, 170, true},
+		{`5'11"`, 180, true},
+		{"6'", 183, true},
+		{"5′ 4″", 163, true},
+		{"5 ft 4 in", 163, true},
+		{`5' 13"`, 0, false},
+		{"tall", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		actual, ok := parseHeight(test.in)
+		if ok != test.ok || actual != test.expected {
+			t.Errorf("%q: expected (%d, %v) but got (%d, %v)", test.in, test.expected, test.ok,
+				actual, ok)
+		}
+	}
+}
+
+func TestUserAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   []*ProfileField
+		expected *ProfileAttributes
+	}{
+		{
+			name:     "Empty",
+			expected: &ProfileAttributes{},
+		},
+		{
+			name: "Imperial",
+			fields: []*ProfileField{
+				{ID: "aboutme_text", Name: "About Alex", DisplayValue: "Hi"},
+				{ID: "lifestyle_height", Name: "Height", DisplayValue: `5' 7" (170 cm)`},
+				{ID: "lifestyle_zodiak", Name: "Star sign", DisplayValue: "Leo"},
+				{ID: "lifestyle_education", Name: "Education", DisplayValue: "In grad school"},
+				{ID: "lifestyle_drinking", Name: "Drinking", DisplayValue: "Socially"},
+				{ID: "lifestyle_smoking", Name: "Smoking", DisplayValue: "Never"},
+				{ID: "lifestyle_exercise", Name: "Exercise", DisplayValue: "Active"},
+				{ID: "lifestyle_kids", Name: "Kids", DisplayValue: "Want someday"},
+				{ID: "lifestyle_religion", Name: "Religion", DisplayValue: "Agnostic"},
+				{ID: "lifestyle_politics", Name: "Politics", DisplayValue: "Moderate"},
+			},
+			expected: &ProfileAttributes{
+				HeightCM:  170,
+				Zodiac:    "Leo",
+				Education: "In grad school",
+				Drinking:  "Socially",
+				Smoking:   "Never",
+				Exercise:  "Active",
+				Kids:      "Want someday",
+				Religion:  "Agnostic",
+				Politics:  "Moderate",
+			},
+		},
+		{
+			name: "Metric",
+			fields: []*ProfileField{
+				{ID: "lifestyle_height", Name: "Height", DisplayValue: "183 cm"},
+				{ID: "lifestyle_kids", Name: "Kids", DisplayValue: "Have and don’t want more"},
+				{ID: "lifestyle_religion", Name: "Religion", DisplayValue: "latter-day saint"},
+			},
+			expected: &ProfileAttributes{
+				HeightCM: 183,
+				Kids:     "Have & don't want more",
+				Religion: "Latter-day Saint",
+			},
+		},
+		{
+			name: "Unknown",
+			fields: []*ProfileField{
+				{ID: "lifestyle_height", Name: "Height", DisplayValue: "very tall"},
+				{ID: "lifestyle_zodiak", Name: "Star sign", DisplayValue: "Ophiuchus"},
+				{ID: "lifestyle_drinking", Name: "Drinking", DisplayValue: "Rarely"},
+				{ID: "lifestyle_pets", Name: "Pets", DisplayValue: "Dog"},
+				{ID: "location", Name: "Location", DisplayValue: "Philadelphia, PA"},
+			},
+			expected: &ProfileAttributes{
+				Drinking: "Rarely",
+				Unknown: map[string]string{
+					"lifestyle_height": "very tall",
+					"lifestyle_zodiak": "Ophiuchus",
+					"lifestyle_pets":   "Dog",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		u := &User{ProfileFields: test.fields}
+		actual := u.Attributes()
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %+v but got %+v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package bumble
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BackupFormatVersion is the version of the archive format
+// produced by WriteBackup.
+const BackupFormatVersion = 1
+
+// Names of the files in a backup archive.
+//
+// Every photo is stored as photos/<id>.jpg, after the
+// metadata for all photos in photos.jsonl. The manifest is
+// always the last file in the archive.
+const (
+	backupLocationsName = "locations.jsonl"
+	backupUsersName     = "users.jsonl"
+	backupPhotosName    = "photos.jsonl"
+	backupPhotoDir      = "photos/"
+	backupManifestName  = "manifest.json"
+)
+
+// A BackupManifest describes the contents of a backup
+// archive.
+type BackupManifest struct {
+	FormatVersion int          `json:"format_version"`
+	Created       time.Time    `json:"created"`
+	Files         []BackupFile `json:"files"`
+}
+
+// A BackupFile records the checksum of one file in a
+// backup archive.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// RestoreStats summarizes the result of RestoreBackup.
+type RestoreStats struct {
+	UsersAdded       int
+	UsersSkipped     int
+	LocationsAdded   int
+	LocationsSkipped int
+	PhotosAdded      int
+	PhotosSkipped    int
+}
+
+// WriteBackup writes a tar archive containing every user,
+// location and photo in db.
+//
+// Every stored version of each user is included, so that
+// profile history can be restored.
+//
+// Photos are read with GetPhoto, so the archive contains
+// decrypted photos even if the photo store is encrypted.
+// Archives of encrypted stores should be protected
+// accordingly.
+func WriteBackup(ctx context.Context, db Database, w io.Writer) error {
+	bw := &backupWriter{w: tar.NewWriter(w)}
+
+	if err := bw.addSpooled(backupLocationsName, func(enc *json.Encoder) error {
+		return writeBackupLocations(ctx, db, enc)
+	}); err != nil {
+		return errors.Wrap(err, "write backup")
+	}
+	if err := bw.addSpooled(backupUsersName, func(enc *json.Encoder) error {
+		return writeBackupUsers(ctx, db, enc)
+	}); err != nil {
+		return errors.Wrap(err, "write backup")
+	}
+
+	var photoIDs []string
+	if err := bw.addSpooled(backupPhotosName, func(enc *json.Encoder) error {
+		photos := db.AllPhotos(ctx)
+		defer photos.Close()
+		for photos.Next() {
+			photo := photos.Value()
+			if err := enc.Encode(photo); err != nil {
+				return err
+			}
+			photoIDs = append(photoIDs, photo.ID)
+		}
+		return photos.Err()
+	}); err != nil {
+		return errors.Wrap(err, "write backup")
+	}
+	for _, id := range photoIDs {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "write backup")
+		}
+		_, data, err := db.GetPhoto(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "write backup: photo "+id)
+		}
+		err = bw.addFile(backupPhotoDir+id+".jpg", int64(len(data)), bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrap(err, "write backup")
+		}
+	}
+
+	manifest, err := json.MarshalIndent(&BackupManifest{
+		FormatVersion: BackupFormatVersion,
+		Created:       time.Now(),
+		Files:         bw.files,
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "write backup")
+	}
+	err = bw.w.WriteHeader(&tar.Header{
+		Name:    backupManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+	})
+	if err == nil {
+		_, err = bw.w.Write(manifest)
+	}
+	if err == nil {
+		err = bw.w.Close()
+	}
+	if err != nil {
+		return errors.Wrap(err, "write backup")
+	}
+	return nil
+}
+
+func writeBackupLocations(ctx context.Context, db Database, enc *json.Encoder) error {
+	locs := db.AllLocations(ctx)
+	defer locs.Close()
+	for locs.Next() {
+		loc := locs.Value()
+		if err := enc.Encode(loc); err != nil {
+			return err
+		}
+	}
+	return locs.Err()
+}
+
+func writeBackupUsers(ctx context.Context, db Database, enc *json.Encoder) error {
+	users := db.AllUsers(ctx)
+	defer users.Close()
+	for users.Next() {
+		u := users.Value()
+		versions, err := collectHistory(ctx, db, u.ID)
+		if err != nil {
+			return err
+		}
+		// Users stored before history was enabled may not
+		// have any history.
+		if len(versions) == 0 || !versions[len(versions)-1].ScanDate.Equal(u.ScanDate) {
+			versions = append(versions, u)
+		}
+		for _, version := range versions {
+			if err := enc.Encode(version); err != nil {
+				return err
+			}
+		}
+	}
+	return users.Err()
+}
+
+func collectHistory(ctx context.Context, db Database, userID string) ([]*User, error) {
+	var res []*User
+	versions := db.UserHistory(ctx, userID)
+	defer versions.Close()
+	for versions.Next() {
+		u := versions.Value()
+		res = append(res, u)
+	}
+	return res, versions.Err()
+}
+
+type backupWriter struct {
+	w     *tar.Writer
+	files []BackupFile
+}
+
+// addSpooled adds a file which is produced by writing
+// JSON objects to an encoder.
+//
+// Since the size of a file must be known before it is
+// added to the archive, the data is first written to a
+// temporary file.
+func (b *backupWriter) addSpooled(name string, fn func(enc *json.Encoder) error) error {
+	tmpFile, err := ioutil.TempFile("", "bumble-backup")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	bufWriter := bufio.NewWriter(tmpFile)
+	if err := fn(json.NewEncoder(bufWriter)); err != nil {
+		return errors.Wrap(err, name)
+	}
+	if err := bufWriter.Flush(); err != nil {
+		return err
+	}
+	size, err := tmpFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return b.addFile(name, size, tmpFile)
+}
+
+func (b *backupWriter) addFile(name string, size int64, r io.Reader) error {
+	err := b.w.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(b.w, hash), r); err != nil {
+		return err
+	}
+	b.files = append(b.files, BackupFile{
+		Name:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+// VerifyBackup reads an entire backup archive and checks
+// that every file matches the checksum in the manifest.
+func VerifyBackup(r io.Reader) (*BackupManifest, error) {
+	tr := tar.NewReader(r)
+	checksums := map[string]string{}
+	var manifest *BackupManifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "verify backup")
+		}
+		if manifest != nil {
+			return nil, errors.New("verify backup: file after manifest: " + header.Name)
+		}
+		if header.Name == backupManifestName {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, errors.Wrap(err, "verify backup: read manifest")
+			}
+			continue
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, tr); err != nil {
+			return nil, errors.Wrap(err, "verify backup")
+		}
+		checksums[header.Name] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if manifest == nil {
+		return nil, errors.New("verify backup: missing manifest")
+	}
+	if manifest.FormatVersion != BackupFormatVersion {
+		return nil, fmt.Errorf("verify backup: unsupported format version %d",
+			manifest.FormatVersion)
+	}
+	if len(manifest.Files) != len(checksums) {
+		return nil, fmt.Errorf("verify backup: manifest lists %d files but archive has %d",
+			len(manifest.Files), len(checksums))
+	}
+	for _, file := range manifest.Files {
+		if actual, ok := checksums[file.Name]; !ok {
+			return nil, errors.New("verify backup: missing file: " + file.Name)
+		} else if actual != file.SHA256 {
+			return nil, errors.New("verify backup: checksum mismatch: " + file.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// RestoreBackup adds the contents of a backup archive to
+// db.
+//
+// Locations and photos which already exist in db are
+// skipped, as are versions of users whose scan dates are
+// already stored. Older versions of a user missing from
+// db are added to its history without replacing its
+// latest version. Thus, restoring the same backup twice
+// has no effect, and an interrupted restore can be
+// resumed.
+//
+// RestoreBackup does not check the archive's checksums;
+// use VerifyBackup for that before restoring.
+func RestoreBackup(ctx context.Context, db Database, r io.Reader) (*RestoreStats, error) {
+	stats := &RestoreStats{}
+	photos := map[string]*Photo{}
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, errors.Wrap(err, "restore backup")
+		}
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, errors.Wrap(err, "restore backup")
+		}
+
+		switch {
+		case header.Name == backupLocationsName:
+			err = restoreLocations(ctx, db, tr, stats)
+		case header.Name == backupUsersName:
+			err = restoreUsers(ctx, db, tr, stats)
+		case header.Name == backupPhotosName:
+			err = decodeJSONLines(tr, func(dec *json.Decoder) error {
+				var photo Photo
+				if err := dec.Decode(&photo); err != nil {
+					return err
+				}
+				photos[photo.ID] = &photo
+				return nil
+			})
+		case strings.HasPrefix(header.Name, backupPhotoDir):
+			id := strings.TrimSuffix(path.Base(header.Name), ".jpg")
+			err = restorePhoto(ctx, db, photos[id], id, tr, stats)
+		}
+		if err != nil {
+			return stats, errors.Wrap(err, "restore backup: "+header.Name)
+		}
+	}
+	return stats, nil
+}
+
+func restoreLocations(ctx context.Context, db Database, r io.Reader, stats *RestoreStats) error {
+	return decodeJSONLines(r, func(dec *json.Decoder) error {
+		var loc Location
+		if err := dec.Decode(&loc); err != nil {
+			return err
+		}
+		if _, err := db.GetLocation(ctx, loc.Name); err == nil {
+			stats.LocationsSkipped++
+			return nil
+		}
+		if err := db.AddLocation(ctx, &loc); err != nil {
+			return err
+		}
+		stats.LocationsAdded++
+		return nil
+	})
+}
+
+func restoreUsers(ctx context.Context, db Database, r io.Reader, stats *RestoreStats) error {
+	// Versions of a user are stored consecutively, so we
+	// only need to buffer the versions of the user we are
+	// currently restoring.
+	var versions []*User
+	err := decodeJSONLines(r, func(dec *json.Decoder) error {
+		var u User
+		if err := dec.Decode(&u); err != nil {
+			return err
+		}
+		if len(versions) > 0 && versions[0].ID != u.ID {
+			if err := restoreUserVersions(ctx, db, versions, stats); err != nil {
+				return err
+			}
+			versions = nil
+		}
+		versions = append(versions, &u)
+		return nil
+	})
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	return restoreUserVersions(ctx, db, versions, stats)
+}
+
+// restoreUserVersions adds the versions of a user whose
+// scan dates are not already stored in db.
+//
+// Scan dates are compared at millisecond precision, since
+// MongoDB stores times no more precisely than that.
+//
+// Since AddUser also replaces the user's profile, the
+// latest stored version is added again if it is newer
+// than every restored version, so that it remains the
+// user's profile.
+func restoreUserVersions(ctx context.Context, db Database, versions []*User,
+	stats *RestoreStats) error {
+	existing, err := collectHistory(ctx, db, versions[0].ID)
+	if err != nil {
+		return err
+	}
+	stored := map[time.Time]bool{}
+	var latest *User
+	for _, u := range existing {
+		stored[scanDateKey(u.ScanDate)] = true
+		if latest == nil || u.ScanDate.After(latest.ScanDate) {
+			latest = u
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].ScanDate.Before(versions[j].ScanDate)
+	})
+	var lastAdded *User
+	for _, u := range versions {
+		if stored[scanDateKey(u.ScanDate)] {
+			stats.UsersSkipped++
+			continue
+		}
+		if err := db.AddUser(ctx, u); err != nil {
+			return err
+		}
+		stored[scanDateKey(u.ScanDate)] = true
+		lastAdded = u
+		stats.UsersAdded++
+	}
+	if lastAdded != nil && latest != nil &&
+		scanDateKey(latest.ScanDate).After(scanDateKey(lastAdded.ScanDate)) {
+		return db.AddUser(ctx, latest)
+	}
+	return nil
+}
+
+// scanDateKey identifies a version of a user by its scan
+// date, at the precision that every Database stores.
+func scanDateKey(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond).UTC()
+}
+
+func restorePhoto(ctx context.Context, db Database, photo *Photo, id string, r io.Reader,
+	stats *RestoreStats) error {
+	if photo == nil {
+		return errors.New("missing metadata for photo " + id)
+	}
+	if exists, err := db.PhotoExists(ctx, id); err != nil {
+		return err
+	} else if exists {
+		stats.PhotosSkipped++
+		return nil
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := db.AddPhoto(ctx, photo, data); err != nil {
+		return err
+	}
+	stats.PhotosAdded++
+	return nil
+}
+
+func decodeJSONLines(r io.Reader, fn func(dec *json.Decoder) error) error {
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		if err := fn(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Command backup writes the entire database, including
+// photos, to a tar archive.
+//
+// The archive can be loaded into any database backend
+// with the restore command.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var outPath string
+	flag.StringVar(&outPath, "out", "-", "output archive, or - for standard output")
+	flag.Parse()
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	if outPath != "-" {
+		f, err := os.Create(outPath)
+		essentials.Must(err)
+		defer f.Close()
+		out = f
+	}
+	bufOut := bufio.NewWriter(out)
+	essentials.Must(bumble.WriteBackup(ctx, db, bufOut))
+	essentials.Must(bufOut.Flush())
+	log.Println("backup: done")
+}
@@ -0,0 +1,238 @@
+package bumble_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/bumble-dump"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src := openBackupTestDatabase(t, "memory://")
+	loc := &bumble.Location{Name: "Philadelphia, PA", Lat: 39.9526, Lon: -75.1652,
+		CountryCode: "us"}
+	if err := src.AddLocation(context.Background(), loc); err != nil {
+		t.Fatal(err)
+	}
+	scanDate := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"Alex", "Alexander"} {
+		u := backupTestUser("user1", name, scanDate.Add(time.Duration(i)*time.Hour))
+		if err := src.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.AddUser(context.Background(), backupTestUser("user2", "Sam", scanDate)); err != nil {
+		t.Fatal(err)
+	}
+	photo := &bumble.Photo{ID: "photo1", LargeURL: "//example.com/photo1.jpg", Width: 512}
+	if err := src.AddPhoto(context.Background(), photo, []byte("jpeg data")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := bumble.WriteBackup(context.Background(), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := bumble.VerifyBackup(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 4 {
+		t.Errorf("expected 4 files in manifest but got %d", len(manifest.Files))
+	}
+
+	dstPath := filepath.Join(tempDir(t), "db.sqlite")
+	dst := openBackupTestDatabase(t, "sqlite://"+dstPath)
+	stats, err := bumble.RestoreBackup(context.Background(), dst, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bumble.RestoreStats{UsersAdded: 3, LocationsAdded: 1, PhotosAdded: 1}
+	if *stats != expected {
+		t.Errorf("expected stats %+v but got %+v", expected, *stats)
+	}
+
+	history := dst.UserHistory(context.Background(), "user1")
+	defer history.Close()
+	var names []string
+	for history.Next() {
+		names = append(names, history.Value().Name)
+	}
+	if err := history.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"Alex", "Alexander"}) {
+		t.Errorf("unexpected history: %v", names)
+	}
+	if _, err := dst.GetLocation(context.Background(), loc.Name); err != nil {
+		t.Error(err)
+	}
+	actualPhoto, data, err := dst.GetPhoto(context.Background(), photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actualPhoto, photo) || string(data) != "jpeg data" {
+		t.Errorf("unexpected photo: %+v %q", actualPhoto, data)
+	}
+
+	stats, err = bumble.RestoreBackup(context.Background(), dst, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = bumble.RestoreStats{UsersSkipped: 3, LocationsSkipped: 1, PhotosSkipped: 1}
+	if *stats != expected {
+		t.Errorf("expected stats %+v after second restore but got %+v", expected, *stats)
+	}
+}
+
+func TestRestoreMissingHistory(t *testing.T) {
+	scanDate := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	var versions []*bumble.User
+	for i, name := range []string{"Alex", "Alexander", "Al"} {
+		versions = append(versions, backupTestUser("user1", name,
+			scanDate.Add(time.Duration(i)*time.Hour)))
+	}
+
+	src := openBackupTestDatabase(t, "memory://")
+	for _, u := range versions[:2] {
+		if err := src.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := bumble.WriteBackup(context.Background(), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The target only has the second and a newer version,
+	// so the first version must be restored into history
+	// without replacing the newest profile.
+	dst := openBackupTestDatabase(t, "memory://")
+	for _, u := range versions[1:] {
+		if err := dst.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := bumble.RestoreBackup(context.Background(), dst, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bumble.RestoreStats{UsersAdded: 1, UsersSkipped: 1}
+	if *stats != expected {
+		t.Errorf("expected stats %+v but got %+v", expected, *stats)
+	}
+
+	history := dst.UserHistory(context.Background(), "user1")
+	defer history.Close()
+	var names []string
+	for history.Next() {
+		names = append(names, history.Value().Name)
+	}
+	if err := history.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"Alex", "Alexander", "Al"}) {
+		t.Errorf("unexpected history: %v", names)
+	}
+	if u, err := dst.GetUser(context.Background(), "user1"); err != nil {
+		t.Fatal(err)
+	} else if u.Name != "Al" {
+		t.Errorf("expected latest profile to be kept but got %s", u.Name)
+	}
+}
+
+func TestRestoreTruncatedScanDates(t *testing.T) {
+	scanDate := time.Date(2019, 6, 1, 0, 0, 0, 123456789, time.UTC)
+	var versions []*bumble.User
+	for i, name := range []string{"Alex", "Alexander"} {
+		versions = append(versions, backupTestUser("user1", name,
+			scanDate.Add(time.Duration(i)*time.Hour)))
+	}
+
+	src := openBackupTestDatabase(t, "memory://")
+	for _, u := range versions {
+		if err := src.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := bumble.WriteBackup(context.Background(), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a database which, like MongoDB, only stores
+	// scan dates to the millisecond.
+	dst := openBackupTestDatabase(t, "memory://")
+	for _, u := range versions {
+		truncated := *u
+		truncated.ScanDate = u.ScanDate.Truncate(time.Millisecond)
+		if err := dst.AddUser(context.Background(), &truncated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := bumble.RestoreBackup(context.Background(), dst, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bumble.RestoreStats{UsersSkipped: 2}
+	if *stats != expected {
+		t.Errorf("expected stats %+v but got %+v", expected, *stats)
+	}
+}
+
+func TestVerifyBackupCorrupt(t *testing.T) {
+	src := openBackupTestDatabase(t, "memory://")
+	photo := &bumble.Photo{ID: "photo1"}
+	err := src.AddPhoto(context.Background(), photo, []byte("some distinctive jpeg data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bumble.WriteBackup(context.Background(), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	idx := bytes.Index(data, []byte("distinctive"))
+	if idx < 0 {
+		t.Fatal("photo data not found in archive")
+	}
+	data[idx] = 'D'
+	if _, err := bumble.VerifyBackup(bytes.NewReader(data)); err == nil {
+		t.Error("expected checksum error")
+	}
+}
+
+func openBackupTestDatabase(t *testing.T, uri string) bumble.Database {
+	return openTestDatabase(t, &bumble.Config{
+		DatabaseURI: uri,
+		PhotosPath:  tempDir(t),
+		KeepHistory: true,
+	})
+}
+
+func backupTestUser(id, name string, scanDate time.Time) *bumble.User {
+	return &bumble.User{
+		ID:       id,
+		Name:     name,
+		Age:      30,
+		ScanDate: scanDate,
+		Location: "Philadelphia, PA",
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bumble-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return dir
+}
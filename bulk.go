@@ -0,0 +1,65 @@
+package bumble
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A BulkError is returned by AddUsers when some of the
+// users in a batch could not be stored. The rest of the
+// batch was stored.
+type BulkError struct {
+	// Failures lists the users that were not stored, in
+	// the order they appeared in the batch.
+	Failures []*BulkFailure
+}
+
+// A BulkFailure describes one user that AddUsers could
+// not store.
+type BulkFailure struct {
+	// Index is the position of the user in the batch.
+	Index  int
+	UserID string
+	Err    error
+}
+
+func (b *BulkError) Error() string {
+	first := b.Failures[0]
+	if len(b.Failures) == 1 {
+		return fmt.Sprintf("add users: user %s: %s", first.UserID, first.Err)
+	}
+	return fmt.Sprintf("add users: %d users failed (first: user %s: %s)", len(b.Failures),
+		first.UserID, first.Err)
+}
+
+// newBulkError creates a BulkError for the failures, or
+// returns nil if there are none.
+func newBulkError(failures []*BulkFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Index < failures[j].Index
+	})
+	return &BulkError{Failures: failures}
+}
+
+// latestUsers finds the index of the last occurrence of
+// each user ID in a batch, ignoring the indices in skip.
+//
+// When a batch contains several versions of a user, only
+// the last one should end up as the user's profile, just
+// like it would when calling AddUser in order.
+func latestUsers(users []*User, skip map[int]bool) []int {
+	seen := map[string]bool{}
+	var res []int
+	for i := len(users) - 1; i >= 0; i-- {
+		if skip[i] || seen[users[i].ID] {
+			continue
+		}
+		seen[users[i].ID] = true
+		res = append(res, i)
+	}
+	sort.Ints(res)
+	return res
+}
@@ -0,0 +1,100 @@
+package bumble
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteAddUsersFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(&Config{
+		DatabaseURI: "sqlite://" + filepath.Join(dir, "db.sqlite"),
+		PhotosPath:  filepath.Join(dir, "photos"),
+		KeepHistory: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Reject some users after their history was written,
+	// which must be rolled back as well.
+	_, err = db.(*sqliteDatabase).db.Exec(`
+		CREATE TRIGGER reject_users BEFORE INSERT ON profiles
+		WHEN NEW.id LIKE 'bad%'
+		BEGIN
+			SELECT RAISE(ABORT, 'rejected');
+		END
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{"good1", "bad1", "good2", "bad2"}
+	var users []*User
+	for _, id := range ids {
+		users = append(users, &User{ID: id})
+	}
+	err = db.AddUsers(context.Background(), users)
+	bulkErr, ok := err.(*BulkError)
+	if !ok {
+		t.Fatalf("expected *BulkError but got %v", err)
+	}
+	var failedIDs []string
+	for i, failure := range bulkErr.Failures {
+		failedIDs = append(failedIDs, failure.UserID)
+		if failure.Index != 2*i+1 {
+			t.Errorf("unexpected index %d for %s", failure.Index, failure.UserID)
+		}
+		if failure.Err == nil {
+			t.Errorf("missing error for %s", failure.UserID)
+		}
+	}
+	if !reflect.DeepEqual(failedIDs, []string{"bad1", "bad2"}) {
+		t.Errorf("unexpected failures: %v", failedIDs)
+	}
+
+	for _, id := range ids {
+		_, err := db.GetUser(context.Background(), id)
+		if stored := err == nil; stored != (id[:3] != "bad") {
+			t.Errorf("user %s: unexpected stored=%v", id, stored)
+		}
+		versions, err := collectHistory(context.Background(), db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hasHistory := len(versions) > 0; hasHistory != (id[:3] != "bad") {
+			t.Errorf("user %s: unexpected history=%v", id, hasHistory)
+		}
+	}
+}
+
+func TestLatestUsers(t *testing.T) {
+	var users []*User
+	for _, id := range []string{"a", "b", "a", "c", "b", "a"} {
+		users = append(users, &User{ID: id})
+	}
+	tests := []struct {
+		skip     map[int]bool
+		expected []int
+	}{
+		{nil, []int{3, 4, 5}},
+		{map[int]bool{5: true}, []int{2, 3, 4}},
+		{map[int]bool{2: true, 5: true, 3: true}, []int{0, 4}},
+	}
+	for _, test := range tests {
+		actual := latestUsers(users, test.skip)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("skip %v: expected %v but got %v", test.skip, test.expected, actual)
+		}
+	}
+}
@@ -1,34 +1,319 @@
 package bumble
 
-import "os"
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/x/network/connstring"
+)
 
 // Config contains the data storage configuration.
 type Config struct {
 	DatabaseURI string
 	PhotosPath  string
+
+	// DatabaseName, if set, is the name of the MongoDB
+	// database.
+	DatabaseName string
+
+	// DatabaseNameFromURI uses the path of DatabaseURI as
+	// the MongoDB database name when DatabaseName is not
+	// set. It is off by default, since the path of a URI
+	// often names the authentication database instead.
+	DatabaseNameFromURI bool
+
+	// Collections names the MongoDB collections. Unset
+	// names use the defaults, so that several isolated
+	// datasets can share a database by using different
+	// collection names.
+	Collections CollectionNames
+
+	// PhotoLayout is the layout of the photo store, such
+	// as PhotoLayoutFlat.
+	PhotoLayout string
+
+	// KeepHistory enables profile history, where every
+	// distinct version of a user is stored rather than
+	// only the most recent one.
+	KeepHistory bool
+
+	// MaxPhotosPerUser is the number of photos downloaded
+	// for each user.
+	MaxPhotosPerUser int
+
+	// Retention is how long users are kept after they were
+	// last scanned, as enforced by the purge command. Zero
+	// means that users are kept forever.
+	Retention time.Duration
+
+	// PseudonymKeyFile, if set, is the path to a secret key
+	// used to pseudonymize users as they are scanned.
+	PseudonymKeyFile string
+
+	// Policy limits which parts of users are stored. A nil
+	// policy keeps everything.
+	Policy *MinimizationPolicy
+
+	// PhotoKeyFile is the path to a hex-encoded key used to
+	// encrypt stored photos. Alternatively, PhotoKey may
+	// contain the hex-encoded key itself. If neither is set,
+	// photos are stored unencrypted.
+	PhotoKeyFile string
+	PhotoKey     string
+
+	// CursorRetries is the number of times in a row that a
+	// MongoDB query streaming users is resumed after its
+	// cursor fails.
+	CursorRetries int
+
+	// Redact enables RedactUser on users as they are
+	// stored, removing phone numbers, emails and social
+	// media handles from their profiles.
+	Redact bool
+
+	// CallTimeout limits how long each non-streaming
+	// Database call, such as GetUser or AddPhoto, may run.
+	// Zero means no limit.
+	//
+	// Streams, such as AllUsers, are not limited, since
+	// they may run for a long time. They stop when their
+	// context is canceled.
+	CallTimeout time.Duration
+
+	// ConnectTimeout limits how long connecting to MongoDB
+	// may take. Zero means no limit.
+	ConnectTimeout time.Duration
 }
 
-// GetConfig gets the configuration from the environment,
-// using default values if necessary.
-func GetConfig() *Config {
+// CollectionNames contains the names of the MongoDB
+// collections that make up a dataset.
+type CollectionNames struct {
+	Profiles      string
+	History       string
+	Photos        string
+	PendingPhotos string
+	Locations     string
+	Meta          string
+}
+
+// GetConfig gets the configuration for a command.
+//
+// Settings are layered, with later layers taking
+// precedence: default values, the config file, and
+// environment variables. Command-line flags are applied
+// on top of this by each command.
+//
+// The config file is given by the -config flag or by the
+// BUMBLE_CONFIG environment variable. Without either, no
+// config file is read.
+func GetConfig() (*Config, error) {
+	path := configFlag(os.Args[1:])
+	if path == "" {
+		path = os.Getenv("BUMBLE_CONFIG")
+	}
+	if flag.CommandLine.Lookup("config") == nil {
+		flag.String("config", path, "path to a JSON config file")
+	}
+	return LoadConfig(path)
+}
+
+// LoadConfig creates a configuration from the config file
+// at path and the environment, using default values if
+// necessary. If path is empty, no config file is read.
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if path != "" {
+		if err := readConfigFile(path, c); err != nil {
+			return nil, err
+		}
+	}
+	c.applyEnv()
+	return c, nil
+}
+
+// DefaultConfig creates the configuration that is used
+// when no settings are given.
+func DefaultConfig() *Config {
 	return &Config{
-		DatabaseURI: getDatabaseURI(),
-		PhotosPath:  getPhotosPath(),
+		DatabaseURI: "mongodb://localhost:27017",
+		PhotosPath:  "./photos",
+		PhotoLayout: PhotoLayoutFlat,
+
+		MaxPhotosPerUser: 2,
+		CursorRetries:    3,
+		Redact:           true,
+		CallTimeout:      time.Minute,
+		ConnectTimeout:   10 * time.Second,
 	}
 }
 
-func getDatabaseURI() string {
-	res := os.Getenv("BUMBLE_DB")
-	if res != "" {
-		return res
+// ConnectContext limits ctx by c.ConnectTimeout, if it is
+// set, for connecting to MongoDB.
+func (c *Config) ConnectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.ConnectTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return "mongodb://localhost:27017"
+	return context.WithTimeout(ctx, c.ConnectTimeout)
 }
 
-func getPhotosPath() string {
-	res := os.Getenv("BUMBLE_PHOTOS")
-	if res != "" {
-		return res
+// MongoDatabaseName gets the name of the MongoDB database
+// to use, which is DatabaseName if it is set, and "bumble"
+// otherwise.
+//
+// If DatabaseNameFromURI is set, the path of DatabaseURI
+// is used before falling back to "bumble".
+func (c *Config) MongoDatabaseName() (string, error) {
+	if c.DatabaseName != "" {
+		return c.DatabaseName, nil
+	} else if !c.DatabaseNameFromURI {
+		return "bumble", nil
+	}
+	connStr, err := connstring.Parse(c.DatabaseURI)
+	if err != nil {
+		return "", errors.Wrap(err, "database name")
+	}
+	if connStr.Database != "" {
+		return connStr.Database, nil
+	}
+	return "bumble", nil
+}
+
+// CollectionNames gets c.Collections with default names
+// filled in for the collections that are not set.
+func (c *Config) CollectionNames() CollectionNames {
+	res := c.Collections
+	fill := func(name *string, def string) {
+		if *name == "" {
+			*name = def
+		}
+	}
+	fill(&res.Profiles, "profiles")
+	fill(&res.History, "profile_history")
+	fill(&res.Photos, "photos")
+	fill(&res.PendingPhotos, "pending_photos")
+	fill(&res.Locations, "locations")
+	fill(&res.Meta, "meta")
+	return res
+}
+
+// PhotoEncryptionKey gets the key for encrypting photos,
+// or nil if photos should not be encrypted.
+func (c *Config) PhotoEncryptionKey() ([]byte, error) {
+	if c.PhotoKeyFile != "" && c.PhotoKey != "" {
+		return nil, errors.New("photo encryption key: both a key and a key file are set")
+	} else if c.PhotoKeyFile != "" {
+		return LoadPhotoKey(c.PhotoKeyFile)
+	} else if c.PhotoKey != "" {
+		return ParsePhotoKey(c.PhotoKey)
+	}
+	return nil, nil
+}
+
+// callContext limits ctx by c.CallTimeout, if it is set.
+func (c *Config) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.CallTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.CallTimeout)
+}
+
+// applyEnv overrides the settings that are set in the
+// environment. Variables with invalid values are ignored.
+func (c *Config) applyEnv() {
+	getString := func(name string, value *string) {
+		if s := os.Getenv(name); s != "" {
+			*value = s
+		}
+	}
+	getString("BUMBLE_DB", &c.DatabaseURI)
+	getString("BUMBLE_DB_NAME", &c.DatabaseName)
+	getString("BUMBLE_PHOTOS", &c.PhotosPath)
+	getString("BUMBLE_PHOTO_LAYOUT", &c.PhotoLayout)
+	getString("BUMBLE_PSEUDONYM_KEY", &c.PseudonymKeyFile)
+	getString("BUMBLE_PHOTO_KEY_FILE", &c.PhotoKeyFile)
+	getString("BUMBLE_PHOTO_KEY", &c.PhotoKey)
+
+	getBool := func(name string, value *bool) {
+		if res, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+			*value = res
+		}
+	}
+	getBool("BUMBLE_DB_NAME_FROM_URI", &c.DatabaseNameFromURI)
+	getBool("BUMBLE_HISTORY", &c.KeepHistory)
+	getBool("BUMBLE_REDACT", &c.Redact)
+
+	getInt := func(name string, value *int) {
+		if res, err := strconv.Atoi(os.Getenv(name)); err == nil && res >= 0 {
+			*value = res
+		}
+	}
+	getInt("BUMBLE_MAX_PHOTOS", &c.MaxPhotosPerUser)
+	getInt("BUMBLE_CURSOR_RETRIES", &c.CursorRetries)
+
+	getDuration := func(name string, value *time.Duration) {
+		if res, err := time.ParseDuration(os.Getenv(name)); err == nil && res >= 0 {
+			*value = res
+		}
+	}
+	getDuration("BUMBLE_CALL_TIMEOUT", &c.CallTimeout)
+	getDuration("BUMBLE_CONNECT_TIMEOUT", &c.ConnectTimeout)
+
+	if s := os.Getenv("BUMBLE_RETENTION"); s != "" {
+		if res, err := ParseRetention(s); err == nil {
+			c.Retention = res
+		}
+	}
+
+	fields := os.Getenv("BUMBLE_KEEP_FIELDS")
+	profileFields := os.Getenv("BUMBLE_KEEP_PROFILE_FIELDS")
+	if fields != "" || profileFields != "" {
+		c.Policy = ParseMinimizationPolicy(fields, profileFields)
+	}
+}
+
+// configFlag finds the value of a -config flag in args
+// without parsing the other flags, since the config is
+// needed to define their defaults.
+func configFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || len(arg)-len(name) > 2 {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		} else if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return ""
+}
+
+// ParseRetention parses a retention period, which is
+// either a time.Duration string or a number of days such
+// as "90d". An empty string means no retention limit.
+func ParseRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, errors.New("parse retention: invalid number of days: " + s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	res, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse retention")
 	}
-	return "./photos"
+	return res, nil
 }
@@ -0,0 +1,149 @@
+package bumble
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// configFile is the JSON format of a config file.
+//
+// Every setting is optional, so fields are pointers that
+// are nil when a setting is absent from the file.
+type configFile struct {
+	DatabaseURI         *string `json:"database_uri"`
+	DatabaseName        *string `json:"database_name"`
+	DatabaseNameFromURI *bool   `json:"database_name_from_uri"`
+
+	Collections *struct {
+		Profiles      *string `json:"profiles"`
+		History       *string `json:"profile_history"`
+		Photos        *string `json:"photos"`
+		PendingPhotos *string `json:"pending_photos"`
+		Locations     *string `json:"locations"`
+		Meta          *string `json:"meta"`
+	} `json:"collections"`
+
+	Photos *struct {
+		Path       *string `json:"path"`
+		Layout     *string `json:"layout"`
+		KeyFile    *string `json:"key_file"`
+		MaxPerUser *int    `json:"max_per_user"`
+	} `json:"photos"`
+
+	History          *bool   `json:"history"`
+	Retention        *string `json:"retention"`
+	CallTimeout      *string `json:"call_timeout"`
+	ConnectTimeout   *string `json:"connect_timeout"`
+	CursorRetries    *int    `json:"cursor_retries"`
+	Redact           *bool   `json:"redact"`
+	PseudonymKeyFile *string `json:"pseudonym_key_file"`
+
+	KeepFields        []string `json:"keep_fields"`
+	KeepProfileFields []string `json:"keep_profile_fields"`
+}
+
+// readConfigFile applies the settings from a JSON config
+// file to c. Unknown keys are rejected, since they are
+// most likely typos.
+func readConfigFile(path string, c *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "read config file")
+	}
+	defer f.Close()
+
+	var obj configFile
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&obj); err != nil {
+		return errors.Wrap(err, "read config file "+path)
+	}
+	if err := obj.apply(c); err != nil {
+		return errors.Wrap(err, "read config file "+path)
+	}
+	return nil
+}
+
+func (f *configFile) apply(c *Config) error {
+	setString(&c.DatabaseURI, f.DatabaseURI)
+	setString(&c.DatabaseName, f.DatabaseName)
+	if f.DatabaseNameFromURI != nil {
+		c.DatabaseNameFromURI = *f.DatabaseNameFromURI
+	}
+	if cs := f.Collections; cs != nil {
+		setString(&c.Collections.Profiles, cs.Profiles)
+		setString(&c.Collections.History, cs.History)
+		setString(&c.Collections.Photos, cs.Photos)
+		setString(&c.Collections.PendingPhotos, cs.PendingPhotos)
+		setString(&c.Collections.Locations, cs.Locations)
+		setString(&c.Collections.Meta, cs.Meta)
+	}
+	if p := f.Photos; p != nil {
+		setString(&c.PhotosPath, p.Path)
+		setString(&c.PhotoLayout, p.Layout)
+		setString(&c.PhotoKeyFile, p.KeyFile)
+		if p.MaxPerUser != nil {
+			if *p.MaxPerUser < 0 {
+				return errors.New("photos.max_per_user must not be negative")
+			}
+			c.MaxPhotosPerUser = *p.MaxPerUser
+		}
+	}
+	if f.History != nil {
+		c.KeepHistory = *f.History
+	}
+	if f.Retention != nil {
+		res, err := ParseRetention(*f.Retention)
+		if err != nil {
+			return err
+		}
+		c.Retention = res
+	}
+	if err := setDuration(&c.CallTimeout, f.CallTimeout, "call_timeout"); err != nil {
+		return err
+	}
+	if err := setDuration(&c.ConnectTimeout, f.ConnectTimeout, "connect_timeout"); err != nil {
+		return err
+	}
+	if f.CursorRetries != nil {
+		if *f.CursorRetries < 0 {
+			return errors.New("cursor_retries must not be negative")
+		}
+		c.CursorRetries = *f.CursorRetries
+	}
+	if f.Redact != nil {
+		c.Redact = *f.Redact
+	}
+	setString(&c.PseudonymKeyFile, f.PseudonymKeyFile)
+	if len(f.KeepFields) > 0 || len(f.KeepProfileFields) > 0 {
+		c.Policy = &MinimizationPolicy{
+			Fields:        f.KeepFields,
+			ProfileFields: f.KeepProfileFields,
+		}
+	}
+	return nil
+}
+
+func setString(dst, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setDuration(dst *time.Duration, src *string, name string) error {
+	if src == nil {
+		return nil
+	}
+	res, err := time.ParseDuration(*src)
+	if err != nil {
+		return errors.Wrap(err, name)
+	}
+	if res < 0 {
+		return errors.New(name + " must not be negative")
+	}
+	*dst = res
+	return nil
+}
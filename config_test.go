@@ -0,0 +1,227 @@
+package bumble
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Duration
+		err      bool
+	}{
+		{"", 0, false},
+		{"90d", 90 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"-3d", 0, true},
+		{"xd", 0, true},
+		{"forever", 0, true},
+	}
+	for _, test := range tests {
+		actual, err := ParseRetention(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected error", test.in)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+		} else if actual != test.expected {
+			t.Errorf("%q: expected %v but got %v", test.in, test.expected, actual)
+		}
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	c := &Config{CallTimeout: time.Minute}
+	ctx, cancel := c.callContext(context.Background())
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		t.Error("expected a deadline")
+	} else if remaining := time.Until(deadline); remaining > time.Minute ||
+		remaining < time.Minute-time.Second {
+		t.Errorf("unexpected time until deadline: %v", remaining)
+	}
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("context was not canceled")
+	}
+
+	c.CallTimeout = 0
+	ctx, cancel = c.callContext(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("unexpected deadline without a timeout")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, `{
+		"database_uri": "mongodb://db:27017",
+		"database_name": "experiment1",
+		"database_name_from_uri": true,
+		"collections": {"profiles": "exp_profiles", "meta": "exp_meta"},
+		"photos": {"path": "/data/photos", "max_per_user": 5},
+		"history": true,
+		"retention": "30d",
+		"call_timeout": "10s",
+		"redact": false,
+		"keep_fields": ["id", "age"]
+	}`)
+	t.Setenv("BUMBLE_PHOTOS", "/override/photos")
+	t.Setenv("BUMBLE_CALL_TIMEOUT", "20s")
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DefaultConfig()
+	expected.DatabaseURI = "mongodb://db:27017"
+	expected.DatabaseName = "experiment1"
+	expected.DatabaseNameFromURI = true
+	expected.Collections = CollectionNames{Profiles: "exp_profiles", Meta: "exp_meta"}
+	expected.PhotosPath = "/override/photos"
+	expected.MaxPhotosPerUser = 5
+	expected.KeepHistory = true
+	expected.Retention = 30 * 24 * time.Hour
+	expected.CallTimeout = 20 * time.Second
+	expected.Redact = false
+	expected.Policy = &MinimizationPolicy{Fields: []string{"id", "age"}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %+v but got %+v", expected, c)
+	}
+
+	names := c.CollectionNames()
+	expectedNames := CollectionNames{
+		Profiles:      "exp_profiles",
+		History:       "profile_history",
+		Photos:        "photos",
+		PendingPhotos: "pending_photos",
+		Locations:     "locations",
+		Meta:          "exp_meta",
+	}
+	if names != expectedNames {
+		t.Errorf("expected collections %+v but got %+v", expectedNames, names)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	clearConfigEnv(t)
+	for _, data := range []string{
+		`{"database_url": "mongodb://db:27017"}`,
+		`{"photos": {"max_per_user": -1}}`,
+		`{"call_timeout": "soon"}`,
+		`{"retention": "-3d"}`,
+		`{"history": "yes"}`,
+	} {
+		if _, err := LoadConfig(writeConfigFile(t, data)); err == nil {
+			t.Errorf("%s: expected error", data)
+		}
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestMongoDatabaseName(t *testing.T) {
+	tests := []struct {
+		uri      string
+		name     string
+		fromURI  bool
+		expected string
+	}{
+		{"mongodb://localhost:27017", "", false, "bumble"},
+		{"mongodb://localhost:27017/admin", "", false, "bumble"},
+		{"mongodb://localhost:27017/dataset", "experiment", false, "experiment"},
+		{"mongodb://localhost:27017", "", true, "bumble"},
+		{"mongodb://localhost:27017/dataset", "", true, "dataset"},
+		{"mongodb://localhost:27017/dataset", "experiment", true, "experiment"},
+	}
+	for _, test := range tests {
+		c := &Config{DatabaseURI: test.uri, DatabaseName: test.name,
+			DatabaseNameFromURI: test.fromURI}
+		actual, err := c.MongoDatabaseName()
+		if err != nil {
+			t.Errorf("%s: %s", test.uri, err)
+		} else if actual != test.expected {
+			t.Errorf("%s %q %v: expected %s but got %s", test.uri, test.name, test.fromURI,
+				test.expected, actual)
+		}
+	}
+}
+
+func TestDatabaseNameFromURIEnv(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("BUMBLE_DB", "mongodb://localhost:27017/dataset")
+
+	c, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := c.MongoDatabaseName(); err != nil {
+		t.Fatal(err)
+	} else if name != "bumble" {
+		t.Errorf("expected URI path to be ignored by default, but got %s", name)
+	}
+
+	t.Setenv("BUMBLE_DB_NAME_FROM_URI", "true")
+	c, err = LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := c.MongoDatabaseName(); err != nil {
+		t.Fatal(err)
+	} else if name != "dataset" {
+		t.Errorf("expected URI path to be used, but got %s", name)
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"-out", "x", "-config", "a.json"}, "a.json"},
+		{[]string{"--config", "a.json"}, "a.json"},
+		{[]string{"-config=a.json", "-redact"}, "a.json"},
+		{[]string{"-config"}, ""},
+		{[]string{"config", "a.json"}, ""},
+		{[]string{"---config", "a.json"}, ""},
+		{[]string{"--", "-config", "a.json"}, ""},
+	}
+	for _, test := range tests {
+		if actual := configFlag(test.args); actual != test.expected {
+			t.Errorf("%v: expected %q but got %q", test.args, test.expected, actual)
+		}
+	}
+}
+
+// clearConfigEnv unsets the environment variables that
+// LoadConfig reads for the duration of a test.
+func clearConfigEnv(t *testing.T) {
+	for _, name := range []string{
+		"BUMBLE_DB", "BUMBLE_DB_NAME", "BUMBLE_DB_NAME_FROM_URI",
+		"BUMBLE_PHOTOS", "BUMBLE_PHOTO_LAYOUT",
+		"BUMBLE_PSEUDONYM_KEY", "BUMBLE_PHOTO_KEY_FILE", "BUMBLE_PHOTO_KEY",
+		"BUMBLE_HISTORY", "BUMBLE_REDACT", "BUMBLE_MAX_PHOTOS", "BUMBLE_CURSOR_RETRIES",
+		"BUMBLE_CALL_TIMEOUT", "BUMBLE_CONNECT_TIMEOUT", "BUMBLE_RETENTION",
+		"BUMBLE_KEEP_FIELDS", "BUMBLE_KEEP_PROFILE_FIELDS",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeConfigFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
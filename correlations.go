@@ -11,12 +11,21 @@ import (
 // and a binary variable determined by v.
 func WordCorrelations(ctx context.Context, db Database,
 	v func(u *User) bool) (map[string]float64, error) {
-	users, errCh := db.AllUsers(ctx)
+	return FilteredWordCorrelations(ctx, db, nil, v)
+}
+
+// FilteredWordCorrelations is like WordCorrelations, but
+// only considers the users matched by a filter.
+func FilteredWordCorrelations(ctx context.Context, db Database, f *UserFilter,
+	v func(u *User) bool) (map[string]float64, error) {
+	users := db.Users(ctx, f)
+	defer users.Close()
 	occur := map[string]int{}
 	cooccur := map[string]int{}
 	numUsers := 0
 	vCount := 0
-	for user := range users {
+	for users.Next() {
+		user := users.Value()
 		numUsers++
 		isV := v(user)
 		if isV {
@@ -29,7 +38,7 @@ func WordCorrelations(ctx context.Context, db Database,
 			}
 		}
 	}
-	if err := <-errCh; err != nil {
+	if err := users.Err(); err != nil {
 		return nil, err
 	}
 
@@ -68,6 +77,12 @@ func WordsInBio(u *User) map[string]int {
 	}
 	bio = strings.Replace(bio, "/", " ", -1)
 	for _, field := range strings.Fields(bio) {
+		// Placeholders from RedactText are counted as-is so
+		// that "<PHONE>" is not confused with "phone".
+		if placeholder := redactionPlaceholder(field); placeholder != "" {
+			res[placeholder]++
+			continue
+		}
 		runes := []rune(field)
 		for len(runes) > 0 && !unicode.IsLetter(runes[0]) {
 			runes = runes[1:]
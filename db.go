@@ -2,9 +2,8 @@ package bumble
 
 import (
 	"context"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,54 +12,191 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrUserNotFound is the cause of the error returned by
+// GetUser for a user that does not exist.
+var ErrUserNotFound = errors.New("no such user")
+
 // A Database is an abstract dating profile database.
 type Database interface {
-	AddUser(u *User) error
-	GetUser(userID string) (*User, error)
-	AllUsers(ctx context.Context) (<-chan *User, <-chan error)
-	UsersAt(ctx context.Context, location string) (<-chan *User, <-chan error)
+	// AddUser inserts or replaces a user.
+	//
+	// If the Config enables KeepHistory, the user is also
+	// added to the user's history, unless it is the same
+	// profile as the previous version. A version with the
+	// same ScanDate as u is replaced.
+	AddUser(ctx context.Context, u *User) error
+
+	// AddUsers adds a batch of users, as if by calling
+	// AddUser on each one in order, but with far fewer
+	// round trips to the database.
+	//
+	// If only some of the users could not be stored, the
+	// error is a *BulkError listing them, and the rest of
+	// the batch was stored. Any other error means that the
+	// batch failed as a whole, although some of its users
+	// may have been stored.
+	AddUsers(ctx context.Context, users []*User) error
+
+	// GetUser gets the latest version of a user.
+	//
+	// If the user does not exist, the error's cause is
+	// ErrUserNotFound.
+	GetUser(ctx context.Context, userID string) (*User, error)
+
+	// DeleteUser removes a user, including its history and
+	// every photo referenced by any version of the user.
+	//
+	// Deleting a user that does not exist is not an error.
+	DeleteUser(ctx context.Context, userID string) error
+
+	// DeleteHistory removes the versions in a user's
+	// history whose ScanDate is before the given time. The
+	// latest version, as returned by GetUser, is kept.
+	//
+	// Photos are not deleted. PruneUserHistory deletes the
+	// photos which only the removed versions reference.
+	DeleteHistory(ctx context.Context, userID string, before time.Time) error
+
+	// RewriteUser modifies every stored version of a user,
+	// including its history, by calling f on each version.
+	//
+	// The function must not change the ID or ScanDate of
+	// the user. Rewriting a user that does not exist is not
+	// an error.
+	RewriteUser(ctx context.Context, userID string, f func(u *User)) error
+
+	// UserHistory streams every stored version of a user,
+	// ordered by ScanDate.
+	//
+	// If the Config does not enable KeepHistory, only the
+	// latest version of the user is produced.
+	UserHistory(ctx context.Context, userID string) *UserIterator
+	AllUsers(ctx context.Context) *UserIterator
+
+	// Users streams the users matching a filter. A nil
+	// filter matches every user.
+	//
+	// Users are produced in order of ID. Thus, the ID of
+	// the last user received serves as a resume token: an
+	// interrupted stream can be continued by passing it as
+	// the filter's AfterID.
+	Users(ctx context.Context, filter *UserFilter) *UserIterator
+
+	UsersAt(ctx context.Context, location string) *UserIterator
 	AllUserLocations(ctx context.Context) ([]string, error)
-	UsersNear(ctx context.Context, lat, lon, maxDist float64) (<-chan *User, <-chan error)
-
-	PhotoExists(id string) (bool, error)
-	AddPhoto(photo *Photo, data []byte) error
-	GetPhoto(id string) (*Photo, []byte, error)
-
-	AddLocation(loc *Location) error
-	GetLocation(name string) (*Location, error)
-	AllLocations(ctx context.Context) (<-chan *Location, <-chan error)
-	LocationsNear(ctx context.Context, lat, lon, maxDist float64) (<-chan *Location, <-chan error)
+	UsersNear(ctx context.Context, lat, lon, maxDist float64) *UserIterator
+
+	PhotoExists(ctx context.Context, id string) (bool, error)
+	AddPhoto(ctx context.Context, photo *Photo, data []byte) error
+	GetPhoto(ctx context.Context, id string) (*Photo, []byte, error)
+
+	// DeletePhoto removes the metadata and data for a
+	// photo. Deleting a photo that does not exist is not an
+	// error.
+	//
+	// A pack photo store keeps the data on disk until it is
+	// compacted with CompactPhotoStore.
+	DeletePhoto(ctx context.Context, id string) error
+
+	// AllPhotos streams the metadata of every stored photo.
+	AllPhotos(ctx context.Context) *PhotoIterator
+
+	AddLocation(ctx context.Context, loc *Location) error
+	GetLocation(ctx context.Context, name string) (*Location, error)
+	AllLocations(ctx context.Context) *LocationIterator
+	LocationsNear(ctx context.Context, lat, lon, maxDist float64) *LocationIterator
+
+	// SchemaVersion gets the version of the last migration
+	// that was applied to the database, or 0 if none were.
+	SchemaVersion(ctx context.Context) (int, error)
+	SetSchemaVersion(ctx context.Context, version int) error
+
+	// Close releases the resources used by the database,
+	// such as its connection. The database must not be used
+	// after it is closed.
+	Close() error
 }
 
 type mongoDatabase struct {
 	config    *Config
+	store     PhotoStore
 	client    *mongo.Client
 	db        *mongo.Database
 	photos    *mongo.Collection
 	profiles  *mongo.Collection
+	history   *mongo.Collection
 	locations *mongo.Collection
+	meta      *mongo.Collection
+	pending   *mongo.Collection
 }
 
+// OpenDatabase opens the database described by c.
+//
+// The backend is chosen by the scheme of c.DatabaseURI:
+// sqlite:// URIs refer to a SQLite file, memory:// opens
+// an empty in-memory database, and all other URIs are
+// passed to MongoDB.
+//
+// For MongoDB, the database and collection names are
+// given by c.MongoDatabaseName and c.CollectionNames.
+// The other backends ignore them.
 func OpenDatabase(c *Config) (Database, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if strings.HasPrefix(c.DatabaseURI, sqliteScheme) {
+		return openSQLiteDatabase(c)
+	} else if strings.HasPrefix(c.DatabaseURI, memoryScheme) {
+		return openMemoryDatabase(c)
+	}
+	return openMongoDatabase(c)
+}
+
+func openMongoDatabase(c *Config) (Database, error) {
+	dbName, err := c.MongoDatabaseName()
+	if err != nil {
+		return nil, err
+	}
+	store, err := OpenConfigPhotoStore(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := c.ConnectContext(context.Background())
 	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(c.DatabaseURI))
 	if err != nil {
 		return nil, err
 	}
-	db := client.Database("bumble")
-	return &mongoDatabase{
+	db := client.Database(dbName)
+	names := c.CollectionNames()
+	res := &mongoDatabase{
 		config:    c,
+		store:     store,
 		client:    client,
 		db:        db,
-		photos:    db.Collection("photos"),
-		profiles:  db.Collection("profiles"),
-		locations: db.Collection("locations"),
-	}, nil
+		photos:    db.Collection(names.Photos),
+		profiles:  db.Collection(names.Profiles),
+		history:   db.Collection(names.History),
+		locations: db.Collection(names.Locations),
+		meta:      db.Collection(names.Meta),
+		pending:   db.Collection(names.PendingPhotos),
+	}
+	recoverCtx, recoverCancel := c.callContext(context.Background())
+	defer recoverCancel()
+	if err := recoverPhotos(recoverCtx, res, store); err != nil {
+		res.Close()
+		return nil, err
+	}
+	return res, nil
 }
 
-func (m *mongoDatabase) AddUser(u *User) error {
-	err := m.profiles.FindOneAndReplace(context.Background(), bson.D{{Key: "id", Value: u.ID}},
+func (m *mongoDatabase) AddUser(ctx context.Context, u *User) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	if m.config.KeepHistory {
+		if err := m.addUserVersion(ctx, u); err != nil {
+			return errors.Wrap(err, "add user")
+		}
+	}
+	err := m.profiles.FindOneAndReplace(ctx, bson.D{{Key: "id", Value: u.ID}},
 		u, options.FindOneAndReplace().SetUpsert(true)).Err()
 	if err != nil {
 		return errors.Wrap(err, "add user")
@@ -68,59 +204,288 @@ func (m *mongoDatabase) AddUser(u *User) error {
 	return nil
 }
 
-func (m *mongoDatabase) GetUser(userID string) (*User, error) {
+// AddUsers upserts the profiles with a single unordered
+// bulk write. If KeepHistory is enabled, each version is
+// still added to the history separately, since it must be
+// compared to the previous version.
+func (m *mongoDatabase) AddUsers(ctx context.Context, users []*User) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+
+	var failures []*BulkFailure
+	failed := map[int]bool{}
+	if m.config.KeepHistory {
+		for i, u := range users {
+			if err := m.addUserVersion(ctx, u); err != nil {
+				if ctx.Err() != nil {
+					return errors.Wrap(err, "add users")
+				}
+				failures = append(failures, &BulkFailure{Index: i, UserID: u.ID, Err: err})
+				failed[i] = true
+			}
+		}
+	}
+
+	indices := latestUsers(users, failed)
+	if len(indices) == 0 {
+		return newBulkError(failures)
+	}
+	models := make([]mongo.WriteModel, len(indices))
+	for i, idx := range indices {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "id", Value: users[idx].ID}}).
+			SetReplacement(users[idx]).
+			SetUpsert(true)
+	}
+	_, err := m.profiles.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if bulkErr, ok := err.(mongo.BulkWriteException); ok && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			idx := indices[writeErr.Index]
+			failures = append(failures, &BulkFailure{
+				Index:  idx,
+				UserID: users[idx].ID,
+				Err:    writeErr.WriteError,
+			})
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "add users")
+	}
+	return newBulkError(failures)
+}
+
+func (m *mongoDatabase) GetUser(ctx context.Context, userID string) (*User, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
 	var user User
-	res := m.photos.FindOne(context.Background(), bson.D{{Key: "id", Value: userID}})
-	if err := res.Decode(&user); err != nil {
+	res := m.profiles.FindOne(ctx, bson.D{{Key: "id", Value: userID}})
+	if err := res.Decode(&user); err == mongo.ErrNoDocuments {
+		return nil, errors.Wrap(ErrUserNotFound, "get user")
+	} else if err != nil {
 		return nil, errors.Wrap(err, "get user")
 	}
 	return &user, nil
 }
 
-func (m *mongoDatabase) users(ctx context.Context, query interface{}) (<-chan *User, <-chan error) {
-	userCh := make(chan *User, 1)
-	errCh := make(chan error, 1)
-	go func() {
-		defer close(userCh)
-		defer close(errCh)
+func (m *mongoDatabase) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	if _, err := DeleteUserPhotos(ctx, m, userID); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	query := bson.D{{Key: "id", Value: userID}}
+	if _, err := m.history.DeleteMany(ctx, query); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	if _, err := m.profiles.DeleteOne(ctx, query); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	return nil
+}
+
+func (m *mongoDatabase) DeleteHistory(ctx context.Context, userID string,
+	before time.Time) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	query := bson.D{
+		{Key: "id", Value: userID},
+		{Key: "scandate", Value: bson.D{{Key: "$lt", Value: before}}},
+	}
+	if _, err := m.history.DeleteMany(ctx, query); err != nil {
+		return errors.Wrap(err, "delete history")
+	}
+	return nil
+}
 
-		cur, err := m.profiles.Find(ctx, query, nil)
+func (m *mongoDatabase) RewriteUser(ctx context.Context, userID string, f func(u *User)) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	var history []*User
+	err := findUsers(ctx, m.history, bson.D{{Key: "id", Value: userID}}, func(u *User) bool {
+		history = append(history, u)
+		return true
+	})
+	if err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	for _, u := range history {
+		f(u)
+		_, err := m.history.ReplaceOne(ctx,
+			bson.D{{Key: "id", Value: u.ID}, {Key: "scandate", Value: u.ScanDate}}, u)
 		if err != nil {
-			errCh <- err
-			return
+			return errors.Wrap(err, "rewrite user")
 		}
-		defer cur.Close(context.Background())
+	}
 
-		for cur.Next(ctx) {
-			var u *User
-			if err := cur.Decode(&u); err != nil {
-				errCh <- err
-				return
+	var user User
+	err = m.profiles.FindOne(ctx, bson.D{{Key: "id", Value: userID}}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	f(&user)
+	if _, err := m.profiles.ReplaceOne(ctx, bson.D{{Key: "id", Value: userID}}, &user); err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	return nil
+}
+
+func (m *mongoDatabase) addUserVersion(ctx context.Context, u *User) error {
+	var prev User
+	query := bson.D{
+		{Key: "id", Value: u.ID},
+		{Key: "scandate", Value: bson.D{{Key: "$lte", Value: u.ScanDate}}},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "scandate", Value: -1}})
+	err := m.history.FindOne(ctx, query, opts).Decode(&prev)
+	if err == nil && prev.SameProfile(u) {
+		return nil
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	// Replace any version with the same ScanDate.
+	filter := bson.D{{Key: "id", Value: u.ID}, {Key: "scandate", Value: u.ScanDate}}
+	_, err = m.history.ReplaceOne(ctx, filter, u, options.Replace().SetUpsert(true))
+	if isDuplicateKey(err) {
+		// A concurrent writer inserted the same version
+		// first, so the unique history index rejected the
+		// upsert. It now matches the existing version.
+		_, err = m.history.ReplaceOne(ctx, filter, u, options.Replace().SetUpsert(true))
+	}
+	return err
+}
+
+// isDuplicateKey checks if a write failed because it
+// violated a unique index.
+func isDuplicateKey(err error) bool {
+	const duplicateKeyCode = 11000
+	switch err := err.(type) {
+	case mongo.WriteException:
+		for _, writeErr := range err.WriteErrors {
+			if writeErr.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return err.Code == duplicateKeyCode
+	}
+	return false
+}
+
+func (m *mongoDatabase) UserHistory(ctx context.Context, userID string) *UserIterator {
+	query := bson.D{{Key: "id", Value: userID}}
+	if !m.config.KeepHistory {
+		return m.users(ctx, query)
+	}
+	return newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+		opts := options.Find().SetSort(bson.D{{Key: "scandate", Value: 1}})
+		return findUsers(ctx, m.history, query, send, opts)
+	})
+}
+
+// users streams the profiles matching a query in order of
+// ID.
+//
+// If the cursor fails partway through, for example because
+// it timed out on the server or the connection dropped,
+// the query is resumed after the last user that was sent.
+// Up to Config.CursorRetries consecutive failures are
+// retried before giving up.
+func (m *mongoDatabase) users(ctx context.Context, query interface{}) *UserIterator {
+	return newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+		var lastID string
+		var failures int
+		for {
+			resumeQuery := query
+			if lastID != "" {
+				resumeQuery = bson.D{{Key: "$and", Value: bson.A{
+					query,
+					bson.D{{Key: "id", Value: bson.D{{Key: "$gt", Value: lastID}}}},
+				}}}
 			}
+			opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}})
+			err := findUsers(ctx, m.profiles, resumeQuery, func(u *User) bool {
+				lastID = u.ID
+				failures = 0
+				return send(u)
+			}, opts)
+			if err == nil || ctx.Err() != nil || failures >= m.config.CursorRetries {
+				return err
+			}
+			failures++
 			select {
-			case userCh <- u:
+			case <-time.After(time.Duration(failures) * time.Second):
 			case <-ctx.Done():
-				errCh <- ctx.Err()
-				return
+				return ctx.Err()
 			}
 		}
+	})
+}
 
-		if cur.Err() != nil {
-			errCh <- cur.Err()
+// findUsers sends every user matching a query to send,
+// stopping early if send returns false.
+func findUsers(ctx context.Context, coll *mongo.Collection, query interface{},
+	send func(u *User) bool, opts ...*options.FindOptions) error {
+	cur, err := coll.Find(ctx, query, opts...)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+	for cur.Next(ctx) {
+		var u *User
+		if err := cur.Decode(&u); err != nil {
+			return err
+		}
+		if !send(u) {
+			return nil
 		}
-	}()
-	return userCh, errCh
+	}
+	return cur.Err()
 }
 
-func (m *mongoDatabase) AllUsers(ctx context.Context) (<-chan *User, <-chan error) {
+func (m *mongoDatabase) AllUsers(ctx context.Context) *UserIterator {
 	return m.users(ctx, bson.D{})
 }
 
-func (m *mongoDatabase) UsersAt(ctx context.Context, location string) (<-chan *User, <-chan error) {
+func (m *mongoDatabase) Users(ctx context.Context, filter *UserFilter) *UserIterator {
+	var countryLocs []string
+	if filter != nil && filter.CountryCode != "" {
+		var err error
+		countryLocs, err = m.countryLocations(ctx, filter.CountryCode)
+		if err != nil {
+			return errorUserIterator(errors.Wrap(err, "get users"))
+		}
+	}
+	return m.users(ctx, filter.mongoQuery(countryLocs))
+}
+
+// countryLocations finds the names of the stored locations
+// in a country.
+func (m *mongoDatabase) countryLocations(ctx context.Context, code string) ([]string, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	locs, err := m.locations.Distinct(ctx, "name", bson.D{{Key: "countrycode", Value: code}})
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, loc := range locs {
+		s, ok := loc.(string)
+		if !ok {
+			return nil, errors.New("unexpected data type")
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func (m *mongoDatabase) UsersAt(ctx context.Context, location string) *UserIterator {
 	return m.users(ctx, bson.D{{Key: "location", Value: location}})
 }
 
 func (m *mongoDatabase) AllUserLocations(ctx context.Context) ([]string, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
 	locs, err := m.profiles.Distinct(ctx, "location", bson.D{})
 	if err != nil {
 		return nil, errors.Wrap(err, "all user locations")
@@ -137,40 +502,20 @@ func (m *mongoDatabase) AllUserLocations(ctx context.Context) ([]string, error)
 }
 
 func (m *mongoDatabase) UsersNear(ctx context.Context, lat, lon,
-	maxDist float64) (<-chan *User, <-chan error) {
-	userCh := make(chan *User, 1)
-	errCh := make(chan error, 1)
-
-	go func() {
-		defer close(userCh)
-		defer close(errCh)
-
-		locations, locErrCh := m.LocationsNear(ctx, lat, lon, maxDist)
-		for loc := range locations {
-			users, userErrCh := m.UsersAt(ctx, loc.Name)
-			for user := range users {
-				select {
-				case userCh <- user:
-				case <-ctx.Done():
-					errCh <- errors.Wrap(ctx.Err(), "get users near")
-					return
-				}
-			}
-			if err := <-userErrCh; err != nil {
-				errCh <- errors.Wrap(err, "get users near")
-				return
-			}
-		}
-		if err := <-locErrCh; err != nil {
-			errCh <- errors.Wrap(err, "get users near")
-		}
-	}()
-
-	return userCh, errCh
+	maxDist float64) *UserIterator {
+	names, err := locationNamesNear(ctx, m, lat, lon, maxDist)
+	if err != nil {
+		return errorUserIterator(errors.Wrap(err, "get users near"))
+	}
+	return m.users(ctx, bson.D{{Key: "location", Value: bson.D{{Key: "$in", Value: names}}}})
 }
 
-func (m *mongoDatabase) PhotoExists(id string) (bool, error) {
-	err := m.photos.FindOne(context.Background(), bson.D{{Key: "id", Value: id}}).Err()
+func (m *mongoDatabase) PhotoExists(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	// SingleResult.Err() does not report ErrNoDocuments
+	// until the result has been read.
+	_, err := m.photos.FindOne(ctx, bson.D{{Key: "id", Value: id}}).DecodeBytes()
 	if err == mongo.ErrNoDocuments {
 		return false, nil
 	} else if err == nil {
@@ -179,51 +524,96 @@ func (m *mongoDatabase) PhotoExists(id string) (bool, error) {
 	return false, errors.Wrap(err, "check photo exists")
 }
 
-func (m *mongoDatabase) AddPhoto(photo *Photo, data []byte) error {
-	tmpFile, err := ioutil.TempFile("", "")
-	if err != nil {
+func (m *mongoDatabase) AddPhoto(ctx context.Context, photo *Photo, data []byte) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	if err := addPhotoTwoPhase(ctx, m, m.store, photo, data); err != nil {
 		return errors.Wrap(err, "add photo")
 	}
+	return nil
+}
+
+type pendingPhoto struct {
+	ID      string
+	Started time.Time
+}
 
-	_, err = tmpFile.Write(data)
-	tmpFile.Close()
+func (m *mongoDatabase) beginPhoto(ctx context.Context, id string) error {
+	return m.pending.FindOneAndReplace(ctx, bson.D{{Key: "id", Value: id}},
+		&pendingPhoto{ID: id, Started: time.Now()},
+		options.FindOneAndReplace().SetUpsert(true)).Err()
+}
+
+// commitPhoto stores the record before removing the
+// pending mark. If the process stops in between, the
+// record exists and rollbackPhoto keeps the data.
+func (m *mongoDatabase) commitPhoto(ctx context.Context, photo *Photo) error {
+	err := m.photos.FindOneAndReplace(ctx, bson.D{{Key: "id", Value: photo.ID}},
+		photo, options.FindOneAndReplace().SetUpsert(true)).Err()
 	if err != nil {
-		os.Remove(tmpFile.Name())
-		return errors.Wrap(err, "add photo")
+		return err
 	}
+	return m.endPhoto(ctx, photo.ID)
+}
 
-	newPath := filepath.Join(m.config.PhotosPath, photo.ID+".jpg")
-	if err := os.Rename(tmpFile.Name(), newPath); err != nil {
-		os.Remove(tmpFile.Name())
-		return errors.Wrap(err, "add photo")
-	}
+func (m *mongoDatabase) endPhoto(ctx context.Context, id string) error {
+	_, err := m.pending.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
+	return err
+}
 
-	err = m.photos.FindOneAndReplace(context.Background(), bson.D{{Key: "id", Value: photo.ID}},
-		photo, options.FindOneAndReplace().SetUpsert(true)).Err()
+func (m *mongoDatabase) pendingPhotos(ctx context.Context, cutoff time.Time) ([]string, error) {
+	cur, err := m.pending.Find(ctx, bson.D{{Key: "started", Value: bson.D{
+		{Key: "$lt", Value: cutoff},
+	}}})
 	if err != nil {
-		os.Remove(newPath)
-		return errors.Wrap(err, "add photo")
+		return nil, err
 	}
+	defer cur.Close(context.Background())
+	var res []string
+	for cur.Next(ctx) {
+		var p pendingPhoto
+		if err := cur.Decode(&p); err != nil {
+			return nil, err
+		}
+		res = append(res, p.ID)
+	}
+	return res, cur.Err()
+}
 
+func (m *mongoDatabase) DeletePhoto(ctx context.Context, id string) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	_, err := m.photos.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
+	if err != nil {
+		return errors.Wrap(err, "delete photo")
+	}
+	if err := m.store.DeletePhoto(id); err != nil {
+		return errors.Wrap(err, "delete photo")
+	}
 	return nil
 }
 
-func (m *mongoDatabase) GetPhoto(id string) (*Photo, []byte, error) {
+func (m *mongoDatabase) GetPhoto(ctx context.Context, id string) (*Photo, []byte, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
 	var photo Photo
-	res := m.photos.FindOne(context.Background(), bson.D{{Key: "id", Value: id}})
+	res := m.photos.FindOne(ctx, bson.D{{Key: "id", Value: id}})
 	if err := res.Decode(&photo); err != nil {
 		return nil, nil, errors.Wrap(err, "get photo")
 	}
-	data, err := ioutil.ReadFile(filepath.Join(m.config.PhotosPath, id+".jpg"))
+	data, err := m.store.ReadPhoto(id)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "get photo")
 	}
 	return &photo, data, nil
 }
 
-func (m *mongoDatabase) AddLocation(loc *Location) error {
-	err := m.locations.FindOneAndReplace(context.Background(),
-		bson.D{{Key: "name", Value: loc.Name}}, loc,
+func (m *mongoDatabase) AddLocation(ctx context.Context, loc *Location) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	locCopy := *loc
+	locCopy.Point = NewGeoPoint(loc.Lat, loc.Lon)
+	err := m.locations.FindOneAndReplace(ctx, bson.D{{Key: "name", Value: loc.Name}}, &locCopy,
 		options.FindOneAndReplace().SetUpsert(true)).Err()
 	if err != nil {
 		return errors.Wrap(err, "add location")
@@ -231,65 +621,215 @@ func (m *mongoDatabase) AddLocation(loc *Location) error {
 	return nil
 }
 
-func (m *mongoDatabase) GetLocation(name string) (*Location, error) {
+func (m *mongoDatabase) GetLocation(ctx context.Context, name string) (*Location, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
 	var loc Location
-	res := m.locations.FindOne(context.Background(), bson.D{{Key: "name", Value: name}})
+	res := m.locations.FindOne(ctx, bson.D{{Key: "name", Value: name}})
 	if err := res.Decode(&loc); err != nil {
 		return nil, errors.Wrap(err, "get location")
 	}
 	return &loc, nil
 }
 
-func (m *mongoDatabase) AllLocations(ctx context.Context) (<-chan *Location, <-chan error) {
-	locCh := make(chan *Location, 1)
-	errCh := make(chan error, 1)
-	go func() {
-		defer close(locCh)
-		defer close(errCh)
-
-		cur, err := m.locations.Find(ctx, bson.D{}, nil)
+func (m *mongoDatabase) AllPhotos(ctx context.Context) *PhotoIterator {
+	return newPhotoIterator(ctx, func(ctx context.Context, send func(p *Photo) bool) error {
+		cur, err := m.photos.Find(ctx, bson.D{}, nil)
 		if err != nil {
-			errCh <- err
-			return
+			return err
 		}
 		defer cur.Close(context.Background())
+		for cur.Next(ctx) {
+			var p *Photo
+			if err := cur.Decode(&p); err != nil {
+				return err
+			}
+			if !send(p) {
+				return nil
+			}
+		}
+		return cur.Err()
+	})
+}
+
+func (m *mongoDatabase) AllLocations(ctx context.Context) *LocationIterator {
+	return m.findLocations(ctx, bson.D{})
+}
 
+func (m *mongoDatabase) findLocations(ctx context.Context, query interface{}) *LocationIterator {
+	return newLocationIterator(ctx, func(ctx context.Context, send func(l *Location) bool) error {
+		cur, err := m.locations.Find(ctx, query, nil)
+		if err != nil {
+			return err
+		}
+		defer cur.Close(context.Background())
 		for cur.Next(ctx) {
 			var l *Location
 			if err := cur.Decode(&l); err != nil {
-				errCh <- err
-				return
+				return err
 			}
-			select {
-			case locCh <- l:
-			case <-ctx.Done():
-				errCh <- ctx.Err()
-				return
+			if !send(l) {
+				return nil
 			}
 		}
+		return cur.Err()
+	})
+}
+
+// LocationsNear finds nearby locations using a geospatial
+// query, which requires a 2dsphere index on "point".
+func (m *mongoDatabase) LocationsNear(ctx context.Context, lat, lon,
+	maxDist float64) *LocationIterator {
+	return m.findLocations(ctx, bson.D{{Key: "point", Value: bson.D{{
+		Key: "$nearSphere",
+		Value: bson.D{
+			{Key: "$geometry", Value: NewGeoPoint(lat, lon)},
+			{Key: "$maxDistance", Value: maxDist * metersPerMile},
+		},
+	}}}})
+}
+
+func (m *mongoDatabase) SchemaVersion(ctx context.Context) (int, error) {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	var doc struct {
+		Value int `bson:"value"`
+	}
+	err := m.meta.FindOne(ctx, bson.D{{Key: "key", Value: "schema_version"}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "get schema version")
+	}
+	return doc.Value, nil
+}
+
+func (m *mongoDatabase) SetSchemaVersion(ctx context.Context, version int) error {
+	ctx, cancel := m.config.callContext(ctx)
+	defer cancel()
+	_, err := m.meta.ReplaceOne(ctx, bson.D{{Key: "key", Value: "schema_version"}},
+		bson.D{{Key: "key", Value: "schema_version"}, {Key: "value", Value: version}},
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "set schema version")
+	}
+	return nil
+}
+
+func (m *mongoDatabase) photoStore() PhotoStore {
+	return m.store
+}
+
+func (m *mongoDatabase) Close() error {
+	ctx, cancel := m.config.callContext(context.Background())
+	defer cancel()
+	if err := m.client.Disconnect(ctx); err != nil {
+		return errors.Wrap(err, "close database")
+	}
+	return nil
+}
+
+// locationNamesNear gets the names of every location
+// produced by db.LocationsNear().
+func locationNamesNear(ctx context.Context, db Database, lat, lon,
+	maxDist float64) ([]string, error) {
+	names := []string{}
+	locs := db.LocationsNear(ctx, lat, lon, maxDist)
+	defer locs.Close()
+	for locs.Next() {
+		names = append(names, locs.Value().Name)
+	}
+	if err := locs.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// UserPhotoIDs finds the IDs of the photos referenced by
+// any stored version of a user, in sorted order.
+func UserPhotoIDs(ctx context.Context, db Database, userID string) ([]string, error) {
+	users, err := collectHistory(ctx, db, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "user photo IDs")
+	}
+	if u, err := db.GetUser(ctx, userID); err == nil {
+		users = append(users, u)
+	} else if errors.Cause(err) != ErrUserNotFound {
+		return nil, errors.Wrap(err, "user photo IDs")
+	}
+	return sortedPhotoIDs(users, nil), nil
+}
+
+// DeleteUserPhotos deletes every photo referenced by any
+// stored version of a user, and returns the number of
+// photos which had records in the database.
+//
+// DeleteUser does this before the user itself is deleted,
+// so that an interrupted deletion can be retried.
+func DeleteUserPhotos(ctx context.Context, db Database, userID string) (int, error) {
+	ids, err := UserPhotoIDs(ctx, db, userID)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete user photos")
+	}
+	count, err := deletePhotos(ctx, db, ids)
+	if err != nil {
+		return count, errors.Wrap(err, "delete user photos")
+	}
+	return count, nil
+}
+
+// deletePhotos deletes photos by ID, and returns the
+// number of them which had records in the database.
+//
+// Data without a record is deleted as well.
+func deletePhotos(ctx context.Context, db Database, ids []string) (int, error) {
+	var count int
+	for _, id := range ids {
+		exists, err := db.PhotoExists(ctx, id)
+		if err != nil {
+			return count, err
+		}
+		if err := db.DeletePhoto(ctx, id); err != nil {
+			return count, err
+		}
+		if exists {
+			count++
+		}
+	}
+	return count, nil
+}
 
-		if cur.Err() != nil {
-			errCh <- cur.Err()
+// sortedPhotoIDs finds the IDs of the photos of some
+// users, excluding the IDs in exclude.
+func sortedPhotoIDs(users []*User, exclude map[string]bool) []string {
+	found := map[string]bool{}
+	var res []string
+	for _, u := range users {
+		for _, photo := range u.AllPhotos() {
+			if !found[photo.ID] && !exclude[photo.ID] {
+				found[photo.ID] = true
+				res = append(res, photo.ID)
+			}
 		}
-	}()
-	return locCh, errCh
+	}
+	sort.Strings(res)
+	return res
 }
 
-func (m *mongoDatabase) LocationsNear(ctx context.Context, lat, lon,
-	maxDist float64) (<-chan *Location, <-chan error) {
-	locCh := make(chan *Location, 1)
-	rawLocs, errCh := m.AllLocations(ctx)
-	go func() {
-		defer close(locCh)
-		for loc := range rawLocs {
-			if loc.Distance(lat, lon) <= maxDist {
-				select {
-				case locCh <- loc:
-				case <-ctx.Done():
-					return
-				}
+// locationsNear implements LocationsNear for a Database
+// without geospatial queries by filtering all of its
+// locations.
+func locationsNear(ctx context.Context, db Database, lat, lon,
+	maxDist float64) *LocationIterator {
+	return newLocationIterator(ctx, func(ctx context.Context, send func(l *Location) bool) error {
+		locs := db.AllLocations(ctx)
+		defer locs.Close()
+		for locs.Next() {
+			loc := locs.Value()
+			if loc.Distance(lat, lon) <= maxDist && !send(loc) {
+				return nil
 			}
 		}
-	}()
-	return locCh, errCh
+		return locs.Err()
+	})
 }
@@ -0,0 +1,80 @@
+package bumble_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/bumble-dump/dbtest"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const testMongoURI = "mongodb://localhost:27017"
+
+func TestMemoryDatabase(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, c *bumble.Config) bumble.Database {
+		c.DatabaseURI = "memory://"
+		return openTestDatabase(t, c)
+	})
+}
+
+func TestSQLiteDatabase(t *testing.T) {
+	layouts := []string{bumble.PhotoLayoutFlat, bumble.PhotoLayoutSharded, bumble.PhotoLayoutPack}
+	for _, layout := range layouts {
+		layout := layout
+		t.Run(layout, func(t *testing.T) {
+			dbtest.Run(t, func(t *testing.T, c *bumble.Config) bumble.Database {
+				c.DatabaseURI = "sqlite://" + filepath.Join(c.PhotosPath, "db.sqlite")
+				c.PhotoLayout = layout
+				return openTestDatabase(t, c)
+			})
+		})
+	}
+	t.Run("encrypted", func(t *testing.T) {
+		dbtest.Run(t, func(t *testing.T, c *bumble.Config) bumble.Database {
+			c.DatabaseURI = "sqlite://" + filepath.Join(c.PhotosPath, "db.sqlite")
+			c.PhotoKey = strings.Repeat("ab", bumble.PhotoKeySize)
+			return openTestDatabase(t, c)
+		})
+	})
+}
+
+func TestMongoDatabase(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(testMongoURI).
+		SetServerSelectionTimeout(time.Second))
+	if err != nil {
+		t.Skip("MongoDB unavailable:", err)
+	}
+	defer client.Disconnect(context.Background())
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skip("MongoDB unavailable:", err)
+	}
+
+	dbtest.Run(t, func(t *testing.T, c *bumble.Config) bumble.Database {
+		dbName := fmt.Sprintf("bumble_test_%d", time.Now().UnixNano())
+		c.DatabaseURI = testMongoURI
+		c.DatabaseName = dbName
+		t.Cleanup(func() {
+			client.Database(dbName).Drop(context.Background())
+		})
+		return openTestDatabase(t, c)
+	})
+}
+
+func openTestDatabase(t *testing.T, c *bumble.Config) bumble.Database {
+	db, err := bumble.OpenDatabase(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
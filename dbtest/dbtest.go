@@ -0,0 +1,1259 @@
+// Package dbtest provides a conformance test suite for
+// implementations of bumble.Database.
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/unixpickle/bumble-dump"
+)
+
+// A Factory creates a new, empty database for a test.
+//
+// The config passed to the factory already has its
+// PhotosPath set to a fresh temporary directory. The
+// factory should fill in any backend-specific fields and
+// open the database, failing the test if it cannot.
+type Factory func(t *testing.T, c *bumble.Config) bumble.Database
+
+// Run runs the full conformance suite against databases
+// produced by f.
+func Run(t *testing.T, f Factory) {
+	tests := []struct {
+		name        string
+		keepHistory bool
+		fn          func(t *testing.T, db bumble.Database)
+	}{
+		{"AddGetUser", false, testAddGetUser},
+		{"UpsertUser", false, testUpsertUser},
+		{"UpsertUserHistory", true, testUpsertUser},
+		{"AddUsers", false, testAddUsers},
+		{"AddUsersHistory", true, testAddUsersHistory},
+		{"History", true, testHistory},
+		{"HistoryDisabled", false, testHistoryDisabled},
+		{"AllUsers", false, testAllUsers},
+		{"UsersAt", false, testUsersAt},
+		{"Users", false, testUsers},
+		{"UsersResume", false, testUsersResume},
+		{"AllUserLocations", false, testAllUserLocations},
+		{"UsersNear", false, testUsersNear},
+		{"StreamCancel", false, testStreamCancel},
+		{"Photos", false, testPhotos},
+		{"AllPhotos", false, testAllPhotos},
+		{"DeletePhoto", false, testDeletePhoto},
+		{"RewriteUser", false, testRewriteUser},
+		{"RewriteUserHistory", true, testRewriteUser},
+		{"DeleteUser", false, testDeleteUser},
+		{"DeleteUserHistory", true, testDeleteUserHistory},
+		{"DeleteUserPhotos", true, testDeleteUserPhotos},
+		{"PruneUserHistory", true, testPruneUserHistory},
+		{"RestoreTwice", true, testRestoreTwice},
+		{"Locations", false, testLocations},
+		{"LocationsNear", false, testLocationsNear},
+		{"SchemaVersion", false, testSchemaVersion},
+		{"Migrate", false, testMigrate},
+		{"MigrateHistoryVersions", true, testMigrateHistoryVersions},
+		{"MigrateHistory", true, testMigrate},
+		{"CanceledContext", false, testCanceledContext},
+		{"Close", false, testClose},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "dbtest")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				os.RemoveAll(dir)
+			})
+			config := &bumble.Config{
+				PhotosPath:  dir,
+				KeepHistory: test.keepHistory,
+			}
+			test.fn(t, f(t, config))
+		})
+	}
+}
+
+func testAddGetUser(t *testing.T, db bumble.Database) {
+	_, err := db.GetUser(context.Background(), "missing")
+	if errors.Cause(err) != bumble.ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound for missing user but got %v", err)
+	}
+
+	user := testUser("user1", "Philadelphia, PA")
+	if err := db.AddUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := db.GetUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsersEqual(t, user, actual)
+}
+
+func testUpsertUser(t *testing.T, db bumble.Database) {
+	user := testUser("user1", "Philadelphia, PA")
+	if err := db.AddUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	user = testUser("user1", "New York, NY")
+	user.Age = 31
+	user.ScanDate = user.ScanDate.Add(time.Hour)
+	if err := db.AddUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := db.GetUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsersEqual(t, user, actual)
+
+	users, err := collectUsers(db.AllUsers(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("expected 1 user but got %d", len(users))
+	}
+}
+
+func testAddUsers(t *testing.T, db bumble.Database) {
+	if err := db.AddUsers(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	batch := addUserBatch(t, db)
+
+	users, err := collectUsers(db.AllUsers(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 20 {
+		t.Errorf("expected 20 users but got %d", len(users))
+	}
+	for _, expected := range batch[1:] {
+		actual, err := db.GetUser(context.Background(), expected.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkUsersEqual(t, expected, actual)
+	}
+}
+
+func testAddUsersHistory(t *testing.T, db bumble.Database) {
+	batch := addUserBatch(t, db)
+	versions, err := collectUsers(db.UserHistory(context.Background(), "user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions but got %d", len(versions))
+	}
+	checkUsersEqual(t, batch[0], versions[0])
+	checkUsersEqual(t, batch[len(batch)-1], versions[1])
+}
+
+// addUserBatch adds 20 users with AddUsers, including two
+// versions of user1: the first and last users in the
+// batch.
+func addUserBatch(t *testing.T, db bumble.Database) []*bumble.User {
+	v1 := testUser("user1", "Philadelphia, PA")
+	v2 := testUser("user1", "New York, NY")
+	v2.ScanDate = v1.ScanDate.Add(time.Hour)
+	batch := []*bumble.User{v1}
+	for i := 2; i <= 20; i++ {
+		batch = append(batch, testUser(fmt.Sprintf("user%d", i), "Philadelphia, PA"))
+	}
+	batch = append(batch, v2)
+	if err := db.AddUsers(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	return batch
+}
+
+func testHistory(t *testing.T, db bumble.Database) {
+	v1 := testUser("user1", "Philadelphia, PA")
+
+	// Only the scan date and distance differ.
+	v2 := testUser("user1", "Philadelphia, PA")
+	v2.ScanDate = v1.ScanDate.Add(time.Hour)
+	v2.DistanceLong = "20 miles away"
+
+	v3 := testUser("user1", "Philadelphia, PA")
+	v3.ScanDate = v1.ScanDate.Add(2 * time.Hour)
+	v3.ProfileFields[1].DisplayValue = "I like cats."
+
+	other := testUser("user2", "New York, NY")
+
+	for _, u := range []*bumble.User{v1, other, v2, v3, v1} {
+		if err := db.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := collectUsers(db.UserHistory(context.Background(), "user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions but got %d", len(versions))
+	}
+	checkUsersEqual(t, v1, versions[0])
+	checkUsersEqual(t, v3, versions[1])
+
+	versions, err = collectUsers(db.UserHistory(context.Background(), "missing"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(versions) != 0 {
+		t.Errorf("expected no versions but got %d", len(versions))
+	}
+}
+
+func testHistoryDisabled(t *testing.T, db bumble.Database) {
+	v1 := testUser("user1", "Philadelphia, PA")
+	v2 := testUser("user1", "Philadelphia, PA")
+	v2.ScanDate = v1.ScanDate.Add(time.Hour)
+	v2.ProfileFields[1].DisplayValue = "I like cats."
+	for _, u := range []*bumble.User{v1, v2} {
+		if err := db.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := collectUsers(db.UserHistory(context.Background(), "user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("expected 1 version but got %d", len(versions))
+	}
+	checkUsersEqual(t, v2, versions[0])
+}
+
+func testAllUsers(t *testing.T, db bumble.Database) {
+	expected := map[string]*bumble.User{}
+	for i := 0; i < 10; i++ {
+		user := testUser(fmt.Sprintf("user%d", i), "Philadelphia, PA")
+		if err := db.AddUser(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+		expected[user.ID] = user
+	}
+	users, err := collectUsers(db.AllUsers(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != len(expected) {
+		t.Fatalf("expected %d users but got %d", len(expected), len(users))
+	}
+	for _, user := range users {
+		if exp, ok := expected[user.ID]; !ok {
+			t.Errorf("unexpected user: %s", user.ID)
+		} else {
+			checkUsersEqual(t, exp, user)
+		}
+	}
+}
+
+func testUsersAt(t *testing.T, db bumble.Database) {
+	addUsers(t, db, map[string]string{
+		"user1": "Philadelphia, PA",
+		"user2": "New York, NY",
+		"user3": "Philadelphia, PA",
+	})
+	checkUserIDs(t, []string{"user1", "user3"}, db.UsersAt(context.Background(), "Philadelphia, PA"))
+	checkUserIDs(t, nil, db.UsersAt(context.Background(), "Boston, MA"))
+}
+
+func testUsers(t *testing.T, db bumble.Database) {
+	addTestLocations(t, db)
+	london := &bumble.Location{Name: "London, UK", Lat: 51.5074, Lon: -0.1278, CountryCode: "gb"}
+	if err := db.AddLocation(context.Background(), london); err != nil {
+		t.Fatal(err)
+	}
+
+	users := []*bumble.User{
+		testUser("user1", "Philadelphia, PA"),
+		testUser("user2", "New York, NY"),
+		testUser("user3", "London, UK"),
+		testUser("user4", "Unknown"),
+	}
+	for i, attrs := range []struct {
+		age      int
+		gender   int
+		verified bool
+		zodiac   string
+	}{
+		{25, 1, true, "Leo"},
+		{30, 2, false, "Aries"},
+		{40, 2, true, ""},
+		{22, 1, false, "Leo"},
+	} {
+		u := users[i]
+		u.Age = attrs.age
+		u.Gender = attrs.gender
+		u.Verified = attrs.verified
+		u.ScanDate = u.ScanDate.Add(time.Duration(i) * 24 * time.Hour)
+		if attrs.zodiac != "" {
+			u.ProfileFields = append(u.ProfileFields, &bumble.ProfileField{
+				ID:           "lifestyle_zodiak",
+				Name:         "Zodiac",
+				DisplayValue: attrs.zodiac,
+			})
+		}
+		if err := db.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	verified := true
+	unverified := false
+	est := time.FixedZone("EST", -5*60*60)
+	tests := []struct {
+		filter   *bumble.UserFilter
+		expected []string
+	}{
+		{nil, []string{"user1", "user2", "user3", "user4"}},
+		{&bumble.UserFilter{}, []string{"user1", "user2", "user3", "user4"}},
+		{&bumble.UserFilter{MinAge: 25}, []string{"user1", "user2", "user3"}},
+		{&bumble.UserFilter{MaxAge: 25}, []string{"user1", "user4"}},
+		{&bumble.UserFilter{MinAge: 26, MaxAge: 35}, []string{"user2"}},
+		{&bumble.UserFilter{Gender: 2}, []string{"user2", "user3"}},
+		{&bumble.UserFilter{Verified: &verified}, []string{"user1", "user3"}},
+		{&bumble.UserFilter{Verified: &unverified}, []string{"user2", "user4"}},
+		{
+			&bumble.UserFilter{Locations: []string{"Unknown", "New York, NY"}},
+			[]string{"user2", "user4"},
+		},
+		{&bumble.UserFilter{CountryCode: "us"}, []string{"user1", "user2"}},
+		{&bumble.UserFilter{CountryCode: "gb"}, []string{"user3"}},
+		{&bumble.UserFilter{CountryCode: "fr"}, nil},
+		{&bumble.UserFilter{FieldID: "lifestyle_zodiak"}, []string{"user1", "user2", "user4"}},
+		{
+			&bumble.UserFilter{FieldID: "lifestyle_zodiak", FieldValue: "Leo"},
+			[]string{"user1", "user4"},
+		},
+		{&bumble.UserFilter{FieldID: "missing_field"}, nil},
+		{
+			&bumble.UserFilter{Gender: 1, Verified: &verified, FieldID: "lifestyle_zodiak"},
+			[]string{"user1"},
+		},
+		{&bumble.UserFilter{CountryCode: "us", Locations: []string{"London, UK"}}, nil},
+		{&bumble.UserFilter{ScannedBefore: users[2].ScanDate}, []string{"user1", "user2"}},
+		{
+			&bumble.UserFilter{ScannedBefore: users[1].ScanDate.Add(time.Hour).In(est)},
+			[]string{"user1", "user2"},
+		},
+		{&bumble.UserFilter{ScannedBefore: users[0].ScanDate}, nil},
+		{&bumble.UserFilter{AfterID: "user2"}, []string{"user3", "user4"}},
+		{&bumble.UserFilter{AfterID: "user2", Gender: 1}, []string{"user4"}},
+	}
+	for _, test := range tests {
+		checkUserIDs(t, test.expected, db.Users(context.Background(), test.filter))
+	}
+}
+
+func testUsersResume(t *testing.T, db bumble.Database) {
+	// Insert the users out of order.
+	addUsers(t, db, map[string]string{
+		"user3": "Philadelphia, PA",
+		"user1": "Philadelphia, PA",
+		"user5": "New York, NY",
+		"user2": "Philadelphia, PA",
+		"user4": "Philadelphia, PA",
+	})
+	filter := &bumble.UserFilter{Locations: []string{"Philadelphia, PA"}}
+
+	// Stop the stream partway through.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	users := db.Users(ctx, filter)
+	var ids []string
+	for users.Next() {
+		ids = append(ids, users.Value().ID)
+		if len(ids) == 2 {
+			cancel()
+			break
+		}
+	}
+	users.Close()
+	if !reflect.DeepEqual(ids, []string{"user1", "user2"}) {
+		t.Fatalf("expected users in order of ID, but got %v", ids)
+	}
+
+	resumed := *filter
+	resumed.AfterID = ids[len(ids)-1]
+	rest, err := collectUsers(db.Users(context.Background(), &resumed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range rest {
+		ids = append(ids, u.ID)
+	}
+	expected := []string{"user1", "user2", "user3", "user4"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v but got %v", expected, ids)
+	}
+}
+
+func testAllUserLocations(t *testing.T, db bumble.Database) {
+	addUsers(t, db, map[string]string{
+		"user1": "Philadelphia, PA",
+		"user2": "New York, NY",
+		"user3": "Philadelphia, PA",
+	})
+	locs, err := db.AllUserLocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(locs)
+	expected := []string{"New York, NY", "Philadelphia, PA"}
+	if !reflect.DeepEqual(locs, expected) {
+		t.Errorf("expected %v but got %v", expected, locs)
+	}
+}
+
+func testUsersNear(t *testing.T, db bumble.Database) {
+	addTestLocations(t, db)
+	addUsers(t, db, map[string]string{
+		"user1": "Philadelphia, PA",
+		"user2": "New York, NY",
+		"user3": "Los Angeles, CA",
+		"user4": "Philadelphia, PA",
+		"user5": "Unknown",
+	})
+	checkUserIDs(t, []string{"user1", "user2", "user4"},
+		db.UsersNear(context.Background(), 40.7128, -74.0060, 100))
+	checkUserIDs(t, []string{"user3"}, db.UsersNear(context.Background(), 34.0522, -118.2437, 10))
+}
+
+func testStreamCancel(t *testing.T, db bumble.Database) {
+	const numItems = 50
+	for i := 0; i < numItems; i++ {
+		user := testUser(fmt.Sprintf("user%d", i), "Philadelphia, PA")
+		if err := db.AddUser(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+		loc := &bumble.Location{
+			Name: fmt.Sprintf("Location %d", i),
+			Lat:  40 + float64(i)/100,
+			Lon:  -75,
+		}
+		if err := db.AddLocation(context.Background(), loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	philadelphia := &bumble.Location{Name: "Philadelphia, PA", Lat: 40, Lon: -75}
+	if err := db.AddLocation(context.Background(), philadelphia); err != nil {
+		t.Fatal(err)
+	}
+
+	streams := map[string]func(ctx context.Context) iterator{
+		"AllUsers": func(ctx context.Context) iterator {
+			return db.AllUsers(ctx)
+		},
+		"UsersAt": func(ctx context.Context) iterator {
+			return db.UsersAt(ctx, "Philadelphia, PA")
+		},
+		"UsersNear": func(ctx context.Context) iterator {
+			return db.UsersNear(ctx, 40, -75, 10)
+		},
+		"AllLocations": func(ctx context.Context) iterator {
+			return db.AllLocations(ctx)
+		},
+		"LocationsNear": func(ctx context.Context) iterator {
+			return db.LocationsNear(ctx, 40, -75, 1000)
+		},
+	}
+	for name, stream := range streams {
+		ctx, cancel := context.WithCancel(context.Background())
+		it := stream(ctx)
+
+		// Read one item, cancel, and then drain the stream.
+		done := make(chan struct{})
+		var count int
+		go func() {
+			defer close(done)
+			for it.Next() {
+				if count == 0 {
+					cancel()
+				}
+				count++
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: stream did not terminate after cancel", name)
+		}
+		cancel()
+
+		if count >= numItems {
+			t.Errorf("%s: received all %d items despite cancel", name, count)
+		}
+		if err := it.Err(); errors.Cause(err) != context.Canceled {
+			t.Errorf("%s: expected context.Canceled but got %v", name, err)
+		}
+		if err := it.Close(); errors.Cause(err) != context.Canceled {
+			t.Errorf("%s: expected Close to return context.Canceled but got %v", name, err)
+		}
+
+		// Closing a stream early is not an error.
+		it = stream(context.Background())
+		if !it.Next() {
+			t.Fatalf("%s: stream was empty: %v", name, it.Err())
+		}
+		if err := it.Close(); err != nil {
+			t.Errorf("%s: unexpected error from Close: %v", name, err)
+		}
+		if it.Next() {
+			t.Errorf("%s: Next returned true after Close", name)
+		}
+	}
+}
+
+func testPhotos(t *testing.T, db bumble.Database) {
+	if exists, err := db.PhotoExists(context.Background(), "photo1"); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("photo should not exist yet")
+	}
+	if _, _, err := db.GetPhoto(context.Background(), "photo1"); err == nil {
+		t.Error("expected error for missing photo")
+	}
+
+	photo := testPhoto("photo1")
+	data := []byte("fake jpeg data")
+	if err := db.AddPhoto(context.Background(), photo, data); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := db.PhotoExists(context.Background(), photo.ID); err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Error("photo should exist")
+	}
+	actual, actualData, err := db.GetPhoto(context.Background(), photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, photo) {
+		t.Errorf("expected photo %v but got %v", photo, actual)
+	}
+	if string(actualData) != string(data) {
+		t.Errorf("expected data %q but got %q", data, actualData)
+	}
+
+	photo.Width = 1024
+	data = []byte("new jpeg data")
+	if err := db.AddPhoto(context.Background(), photo, data); err != nil {
+		t.Fatal(err)
+	}
+	actual, actualData, err = db.GetPhoto(context.Background(), photo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, photo) {
+		t.Errorf("expected photo %v but got %v", photo, actual)
+	}
+	if string(actualData) != string(data) {
+		t.Errorf("expected data %q but got %q", data, actualData)
+	}
+}
+
+func testAllPhotos(t *testing.T, db bumble.Database) {
+	expected := map[string]*bumble.Photo{}
+	for _, id := range []string{"photo1", "photo2", "photo3"} {
+		photo := testPhoto(id)
+		if err := db.AddPhoto(context.Background(), photo, []byte("data for "+id)); err != nil {
+			t.Fatal(err)
+		}
+		expected[id] = photo
+	}
+	photos := db.AllPhotos(context.Background())
+	defer photos.Close()
+	actual := map[string]*bumble.Photo{}
+	for photos.Next() {
+		photo := photos.Value()
+		actual[photo.ID] = photo
+	}
+	if err := photos.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected photos %v but got %v", expected, actual)
+	}
+}
+
+func testRewriteUser(t *testing.T, db bumble.Database) {
+	u1 := testUser("user1", "Philadelphia, PA")
+	u2 := testUser("user1", "New York, NY")
+	u2.ScanDate = u2.ScanDate.Add(time.Hour)
+	other := testUser("user2", "Philadelphia, PA")
+	for _, u := range []*bumble.User{u1, u2, other} {
+		if err := db.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rewrite := func(u *bumble.User) {
+		u.Name += " (rewritten)"
+		u.Location = "Unknown"
+	}
+	if err := db.RewriteUser(context.Background(), "user1", rewrite); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RewriteUser(context.Background(), "missing", rewrite); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*bumble.User{u1, u2} {
+		rewrite(u)
+	}
+	actual, err := db.GetUser(context.Background(), "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsersEqual(t, u2, actual)
+	checkUserIDs(t, []string{"user1"}, db.UsersAt(context.Background(), "Unknown"))
+
+	history, err := collectUsers(db.UserHistory(context.Background(), "user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) == 2 {
+		checkUsersEqual(t, u1, history[0])
+		checkUsersEqual(t, u2, history[1])
+	} else if len(history) == 1 {
+		checkUsersEqual(t, u2, history[0])
+	} else {
+		t.Errorf("unexpected history length: %d", len(history))
+	}
+
+	actual, err = db.GetUser(context.Background(), "user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsersEqual(t, other, actual)
+}
+
+func testDeletePhoto(t *testing.T, db bumble.Database) {
+	for _, id := range []string{"photo1", "photo2"} {
+		if err := db.AddPhoto(context.Background(), testPhoto(id), []byte("data for "+id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.DeletePhoto(context.Background(), "photo1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exists, err := db.PhotoExists(context.Background(), "photo1"); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("deleted photo still exists")
+	}
+	if _, _, err := db.GetPhoto(context.Background(), "photo1"); err == nil {
+		t.Error("expected error for deleted photo")
+	}
+	if _, data, err := db.GetPhoto(context.Background(), "photo2"); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "data for photo2" {
+		t.Errorf("unexpected data for photo2: %q", data)
+	}
+}
+
+func testDeleteUser(t *testing.T, db bumble.Database) {
+	// Without history, the old photo of user1 is not
+	// referenced by any stored version of the user.
+	checkDeleteUser(t, db, true)
+}
+
+func testDeleteUserHistory(t *testing.T, db bumble.Database) {
+	checkDeleteUser(t, db, false)
+}
+
+func checkDeleteUser(t *testing.T, db bumble.Database, keepsOldPhoto bool) {
+	user1 := testUser("user1", "Philadelphia, PA")
+	if err := db.AddUser(context.Background(), user1); err != nil {
+		t.Fatal(err)
+	}
+	// Give the latest version a different photo, so that
+	// both versions' photos must be found when history is
+	// enabled.
+	user1New := testUser("user1", "Philadelphia, PA")
+	user1New.ScanDate = user1New.ScanDate.Add(time.Hour)
+	user1New.Albums[0].Photos = []*bumble.Photo{testPhoto("photo_user1_new")}
+	if err := db.AddUser(context.Background(), user1New); err != nil {
+		t.Fatal(err)
+	}
+	user2 := testUser("user2", "New York, NY")
+	if err := db.AddUser(context.Background(), user2); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"photo_user1", "photo_user1_new", "photo_user2"} {
+		if err := db.AddPhoto(context.Background(), testPhoto(id), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DeleteUser(context.Background(), "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteUser(context.Background(), "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUser(context.Background(), "user1"); err == nil {
+		t.Error("expected error for deleted user")
+	}
+	history, err := collectUsers(db.UserHistory(context.Background(), "user1"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(history) != 0 {
+		t.Errorf("expected no history but got %d versions", len(history))
+	}
+	checkUserIDs(t, []string{"user2"}, db.AllUsers(context.Background()))
+
+	expectedPhotos := map[string]bool{
+		"photo_user1":     keepsOldPhoto,
+		"photo_user1_new": false,
+		"photo_user2":     true,
+	}
+	for id, expected := range expectedPhotos {
+		if exists, err := db.PhotoExists(context.Background(), id); err != nil {
+			t.Fatal(err)
+		} else if exists != expected {
+			t.Errorf("photo %s: expected exists=%v but got %v", id, expected, exists)
+		}
+	}
+}
+
+func testDeleteUserPhotos(t *testing.T, db bumble.Database) {
+	ctx := context.Background()
+	user1 := testUser("user1", "Philadelphia, PA")
+	user1New := testUser("user1", "Philadelphia, PA")
+	user1New.ScanDate = user1New.ScanDate.Add(time.Hour)
+	user1New.Albums[0].Photos = []*bumble.Photo{testPhoto("photo_user1_new")}
+	for _, u := range []*bumble.User{user1, user1New} {
+		if err := db.AddUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"photo_user1", "photo_user1_new"} {
+		if err := db.AddPhoto(ctx, testPhoto(id), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := bumble.DeleteUserPhotos(ctx, db, "user1"); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("expected 2 deleted photos but got %d", n)
+	}
+	for _, id := range []string{"photo_user1", "photo_user1_new"} {
+		if exists, err := db.PhotoExists(ctx, id); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Errorf("photo %s was not deleted", id)
+		}
+	}
+	if _, err := db.GetUser(ctx, "user1"); err != nil {
+		t.Error("user was deleted:", err)
+	}
+	if n, err := bumble.DeleteUserPhotos(ctx, db, "user1"); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("expected no deleted photos but got %d", n)
+	}
+}
+
+func testPruneUserHistory(t *testing.T, db bumble.Database) {
+	ctx := context.Background()
+	addVersion := func(id string, offset time.Duration, photoIDs ...string) time.Time {
+		u := testUser(id, "Philadelphia, PA")
+		u.ScanDate = u.ScanDate.Add(offset)
+		u.Albums[0].Photos = nil
+		for _, photoID := range photoIDs {
+			u.Albums[0].Photos = append(u.Albums[0].Photos, testPhoto(photoID))
+		}
+		if err := db.AddUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		return u.ScanDate
+	}
+	addVersion("user1", 0, "shared", "old")
+	addVersion("user1", time.Hour, "shared", "mid")
+	latest1 := addVersion("user1", 3*time.Hour, "shared", "new")
+
+	// The latest version of user2 is the same profile as
+	// its second version, so that version stands for it.
+	addVersion("user2", 0, "user2_old")
+	kept2 := addVersion("user2", time.Hour, "user2_kept")
+	latest2 := addVersion("user2", 3*time.Hour, "user2_kept")
+
+	photoIDs := []string{"shared", "old", "mid", "new", "user2_old", "user2_kept"}
+	for _, id := range photoIDs {
+		if err := db.AddPhoto(ctx, testPhoto(id), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cutoff := testUser("", "").ScanDate.Add(2 * time.Hour)
+	expired, err := bumble.FindExpiredHistory(ctx, db, "user1", cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired.Versions != 2 || !reflect.DeepEqual(expired.PhotoIDs, []string{"mid", "old"}) {
+		t.Errorf("unexpected expired history: %+v", expired)
+	}
+	for _, id := range []string{"user1", "user2"} {
+		if _, err := bumble.PruneUserHistory(ctx, db, id, cutoff); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, scanDates := range map[string][]time.Time{
+		"user1": {latest1},
+		"user2": {kept2},
+	} {
+		versions, err := collectUsers(db.UserHistory(ctx, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []time.Time
+		for _, v := range versions {
+			actual = append(actual, v.ScanDate)
+		}
+		if len(actual) != len(scanDates) || !actual[0].Equal(scanDates[0]) {
+			t.Errorf("%s: expected history %v but got %v", id, scanDates, actual)
+		}
+	}
+	expectedPhotos := map[string]bool{
+		"shared":     true,
+		"old":        false,
+		"mid":        false,
+		"new":        true,
+		"user2_old":  false,
+		"user2_kept": true,
+	}
+	for id, expected := range expectedPhotos {
+		if exists, err := db.PhotoExists(ctx, id); err != nil {
+			t.Fatal(err)
+		} else if exists != expected {
+			t.Errorf("photo %s: expected exists=%v but got %v", id, expected, exists)
+		}
+	}
+	if u, err := db.GetUser(ctx, "user2"); err != nil {
+		t.Fatal(err)
+	} else if !u.ScanDate.Equal(latest2) {
+		t.Errorf("unexpected latest scan date for user2: %v", u.ScanDate)
+	}
+}
+
+func testRestoreTwice(t *testing.T, db bumble.Database) {
+	ctx := context.Background()
+	src, err := bumble.OpenDatabase(&bumble.Config{DatabaseURI: "memory://", KeepHistory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	// Scan dates finer than a millisecond are not kept by
+	// every backend.
+	for i := 0; i < 2; i++ {
+		u := testUser("user1", "Philadelphia, PA")
+		u.ScanDate = u.ScanDate.Add(time.Duration(i)*time.Hour + 123456789)
+		u.Name = fmt.Sprintf("Name %d", i)
+		if err := src.AddUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.AddPhoto(ctx, testPhoto("photo_user1"), []byte("photo data")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bumble.WriteBackup(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bumble.RestoreBackup(ctx, db, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := bumble.RestoreBackup(ctx, db, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bumble.RestoreStats{UsersSkipped: 2, PhotosSkipped: 1}
+	if *stats != expected {
+		t.Errorf("expected stats %+v but got %+v", expected, *stats)
+	}
+	history, err := collectUsers(db.UserHistory(ctx, "user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected 2 versions but got %d", len(history))
+	}
+	if u, err := db.GetUser(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	} else if u.Name != "Name 1" {
+		t.Errorf("unexpected latest version: %s", u.Name)
+	}
+}
+
+func testLocations(t *testing.T, db bumble.Database) {
+	if _, err := db.GetLocation(context.Background(), "Philadelphia, PA"); err == nil {
+		t.Error("expected error for missing location")
+	}
+	addTestLocations(t, db)
+
+	loc := &bumble.Location{Name: "Philadelphia, PA", Lat: 39.9526, Lon: -75.1652, CountryCode: "us"}
+	if err := db.AddLocation(context.Background(), loc); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := db.GetLocation(context.Background(), loc.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLocationsEqual(t, loc, actual)
+
+	locs, err := collectLocations(db.AllLocations(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != len(sampleLocations) {
+		t.Fatalf("expected %d locations but got %d", len(sampleLocations), len(locs))
+	}
+	for _, loc := range locs {
+		var found bool
+		for _, expected := range sampleLocations {
+			if expected.Name == loc.Name {
+				checkLocationsEqual(t, expected, loc)
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("unexpected location: %s", loc.Name)
+		}
+	}
+}
+
+func testLocationsNear(t *testing.T, db bumble.Database) {
+	addTestLocations(t, db)
+
+	tests := []struct {
+		lat      float64
+		lon      float64
+		maxDist  float64
+		expected []string
+	}{
+		{40.7128, -74.0060, 10, []string{"New York, NY"}},
+		{40.7128, -74.0060, 100, []string{"New York, NY", "Philadelphia, PA"}},
+		{34.0522, -118.2437, 100, []string{"Los Angeles, CA"}},
+		{51.5074, -0.1278, 100, nil},
+		{40.7128, -74.0060, 10000, []string{"Los Angeles, CA", "New York, NY", "Philadelphia, PA"}},
+	}
+	for _, test := range tests {
+		locs, err := collectLocations(db.LocationsNear(context.Background(), test.lat, test.lon,
+			test.maxDist))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, loc := range locs {
+			names = append(names, loc.Name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("near %f,%f (%f miles): expected %v but got %v", test.lat, test.lon,
+				test.maxDist, test.expected, names)
+		}
+	}
+}
+
+func testSchemaVersion(t *testing.T, db bumble.Database) {
+	ctx := context.Background()
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if version != 0 {
+		t.Errorf("expected initial version 0 but got %d", version)
+	}
+	for _, expected := range []int{3, 1} {
+		if err := db.SetSchemaVersion(ctx, expected); err != nil {
+			t.Fatal(err)
+		}
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		} else if version != expected {
+			t.Errorf("expected version %d but got %d", expected, version)
+		}
+	}
+}
+
+func testMigrate(t *testing.T, db bumble.Database) {
+	ctx := context.Background()
+	addTestLocations(t, db)
+	legacy := testUser("user1", "Philadelphia, PA")
+	legacy.Location = ""
+	if err := db.AddUser(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	current := testUser("user2", "New York, NY")
+	if err := db.AddUser(context.Background(), current); err != nil {
+		t.Fatal(err)
+	}
+
+	// Running twice checks that a migrated database is left
+	// untouched.
+	for i := 0; i < 2; i++ {
+		if err := bumble.Migrate(ctx, db, nil); err != nil {
+			t.Fatal(err)
+		}
+		version, err := db.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest := bumble.Migrations[len(bumble.Migrations)-1].Version
+		if version != latest {
+			t.Errorf("expected version %d but got %d", latest, version)
+		}
+		pending, err := bumble.PendingMigrations(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(pending) != 0 {
+			t.Errorf("expected no pending migrations but got %d", len(pending))
+		}
+
+		for _, expected := range []*bumble.User{testUser("user1", "Philadelphia, PA"), current} {
+			actual, err := db.GetUser(context.Background(), expected.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkUsersEqual(t, expected, actual)
+		}
+		for _, expected := range sampleLocations {
+			actual, err := db.GetLocation(context.Background(), expected.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLocationsEqual(t, expected, actual)
+		}
+	}
+}
+
+func testMigrateHistoryVersions(t *testing.T, db bumble.Database) {
+	ctx := context.Background()
+	old := testUser("user1", "Philadelphia, PA")
+	old.Location = ""
+	latest := testUser("user1", "New York, NY")
+	latest.ScanDate = latest.ScanDate.Add(time.Hour)
+	for _, u := range []*bumble.User{old, latest} {
+		if err := db.AddUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bumble.Migrate(ctx, db, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old version is fixed in place, without adding
+	// versions or changing the latest one.
+	history, err := collectUsers(db.UserHistory(ctx, "user1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 versions but got %d", len(history))
+	}
+	checkUsersEqual(t, testUser("user1", "Philadelphia, PA"), history[0])
+	checkUsersEqual(t, latest, history[1])
+	actual, err := db.GetUser(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsersEqual(t, latest, actual)
+}
+
+var sampleLocations = []*bumble.Location{
+	{Name: "Philadelphia, PA", Lat: 39.9526, Lon: -75.1652, CountryCode: "us"},
+	{Name: "New York, NY", Lat: 40.7128, Lon: -74.0060, CountryCode: "us"},
+	{Name: "Los Angeles, CA", Lat: 34.0522, Lon: -118.2437, CountryCode: "us"},
+}
+
+func testCanceledContext(t *testing.T, db bumble.Database) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := db.AddUser(ctx, testUser("user1", "Philadelphia, PA")); err == nil {
+		t.Error("expected error adding user")
+	}
+	if _, err := db.GetUser(context.Background(), "user1"); err == nil {
+		t.Error("user was added despite canceled context")
+	}
+	addUsers(t, db, map[string]string{"user2": "Philadelphia, PA"})
+	if _, err := db.GetUser(ctx, "user2"); err == nil {
+		t.Error("expected error getting user")
+	}
+	if err := db.DeleteUser(ctx, "user2"); err == nil {
+		t.Error("expected error deleting user")
+	}
+
+	if err := db.AddPhoto(ctx, testPhoto("photo1"), []byte("data")); err == nil {
+		t.Error("expected error adding photo")
+	}
+	if exists, err := db.PhotoExists(context.Background(), "photo1"); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("photo was added despite canceled context")
+	}
+
+	loc := &bumble.Location{Name: "Philadelphia, PA", Lat: 39.9526, Lon: -75.1652}
+	if err := db.AddLocation(ctx, loc); err == nil {
+		t.Error("expected error adding location")
+	}
+	if _, err := db.GetLocation(context.Background(), loc.Name); err == nil {
+		t.Error("location was added despite canceled context")
+	}
+}
+
+func testClose(t *testing.T, db bumble.Database) {
+	addUsers(t, db, map[string]string{"user1": "Philadelphia, PA"})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func addTestLocations(t *testing.T, db bumble.Database) {
+	for _, loc := range sampleLocations {
+		locCopy := *loc
+		if err := db.AddLocation(context.Background(), &locCopy); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func addUsers(t *testing.T, db bumble.Database, idToLocation map[string]string) {
+	for id, location := range idToLocation {
+		if err := db.AddUser(context.Background(), testUser(id, location)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testUser(id, location string) *bumble.User {
+	return &bumble.User{
+		ID:            id,
+		Name:          "Name " + id,
+		Age:           30,
+		Gender:        2,
+		Verified:      true,
+		DistanceLong:  "10 miles away",
+		DistanceShort: "10 mi",
+		Albums: []*bumble.Album{
+			{
+				UID:     "album_" + id,
+				Name:    "Photos",
+				Caption: "",
+				Photos:  []*bumble.Photo{testPhoto("photo_" + id)},
+			},
+		},
+		MusicServices: []*bumble.MusicService{
+			{
+				ID:          "spotify",
+				DisplayName: "Spotify",
+				Type:        1,
+				TopArtists:  []*bumble.MusicArtist{{ID: "artist1", Name: "Some Artist"}},
+			},
+		},
+		ProfileFields: []*bumble.ProfileField{
+			{ID: "location", Type: 1, Name: "Location", DisplayValue: location + "\n10 miles away"},
+			{ID: "aboutme_text", Type: 2, Name: "About me", DisplayValue: "I like dogs."},
+		},
+		ScanDate: time.Date(2019, 6, 1, 12, 30, 0, 0, time.UTC),
+		Location: location,
+	}
+}
+
+func testPhoto(id string) *bumble.Photo {
+	return &bumble.Photo{
+		ID:              id,
+		PreviewURL:      "//example.com/" + id + "_preview.jpg",
+		LargeURL:        "//example.com/" + id + "_large.jpg",
+		FaceTopLeft:     [2]int{10, 20},
+		FaceBottomRight: [2]int{100, 120},
+		Width:           512,
+		Height:          640,
+	}
+}
+
+func checkUsersEqual(t *testing.T, expected, actual *bumble.User) {
+	if !expected.ScanDate.Equal(actual.ScanDate) {
+		t.Errorf("user %s: expected scan date %v but got %v", expected.ID, expected.ScanDate,
+			actual.ScanDate)
+	}
+	u1, u2 := *expected, *actual
+	u1.ScanDate, u2.ScanDate = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(u1, u2) {
+		t.Errorf("user %s: expected %+v but got %+v", expected.ID, u1, u2)
+	}
+}
+
+func checkLocationsEqual(t *testing.T, expected, actual *bumble.Location) {
+	expectedCopy := *expected
+	expectedCopy.Point = bumble.NewGeoPoint(expected.Lat, expected.Lon)
+	if !reflect.DeepEqual(&expectedCopy, actual) {
+		t.Errorf("expected location %+v but got %+v", &expectedCopy, actual)
+	}
+}
+
+func checkUserIDs(t *testing.T, expected []string, it *bumble.UserIterator) {
+	users, err := collectUsers(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected users %v but got %v", expected, ids)
+	}
+}
+
+func collectUsers(it *bumble.UserIterator) ([]*bumble.User, error) {
+	defer it.Close()
+	var res []*bumble.User
+	for it.Next() {
+		res = append(res, it.Value())
+	}
+	return res, it.Err()
+}
+
+func collectLocations(it *bumble.LocationIterator) ([]*bumble.Location, error) {
+	defer it.Close()
+	var res []*bumble.Location
+	for it.Next() {
+		res = append(res, it.Value())
+	}
+	return res, it.Err()
+}
+
+// iterator is implemented by every stream returned by a
+// Database.
+type iterator interface {
+	Next() bool
+	Err() error
+	Close() error
+}
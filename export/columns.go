@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/unixpickle/bumble-dump"
+)
+
+// columnNames lists the user fields which can be selected
+// as columns, in their default order.
+var columnNames = []string{
+	"id",
+	"name",
+	"age",
+	"gender",
+	"verified",
+	"distance_long",
+	"distance_short",
+	"location",
+	"scan_date",
+	"num_photos",
+}
+
+type columnType int
+
+const (
+	stringColumn columnType = iota
+	intColumn
+	boolColumn
+	timeColumn
+)
+
+// A column is one value in a flat row describing a user.
+type column struct {
+	Name string
+	Type columnType
+
+	// Optional columns may be missing for some users, in
+	// which case Value returns nil.
+	Optional bool
+
+	// Value gets the column's value for a user, which is a
+	// string, int, bool, time.Time or nil.
+	Value func(u *bumble.User) interface{}
+}
+
+// selectColumns creates the columns for a list of user
+// fields followed by a list of profile field IDs.
+//
+// Profile field columns are named field_<id>.
+func selectColumns(names, profileFieldIDs []string) ([]*column, error) {
+	var res []*column
+	for _, name := range names {
+		col := userColumn(name)
+		if col == nil {
+			return nil, errors.New("unknown field: " + name)
+		}
+		res = append(res, col)
+	}
+	for _, id := range profileFieldIDs {
+		res = append(res, profileFieldColumn(id))
+	}
+	return res, nil
+}
+
+func userColumn(name string) *column {
+	col := &column{Name: name, Type: stringColumn}
+	switch name {
+	case "id":
+		col.Value = func(u *bumble.User) interface{} { return u.ID }
+	case "name":
+		col.Value = func(u *bumble.User) interface{} { return u.Name }
+	case "age":
+		col.Type = intColumn
+		col.Value = func(u *bumble.User) interface{} { return u.Age }
+	case "gender":
+		col.Type = intColumn
+		col.Value = func(u *bumble.User) interface{} { return u.Gender }
+	case "verified":
+		col.Type = boolColumn
+		col.Value = func(u *bumble.User) interface{} { return u.Verified }
+	case "distance_long":
+		col.Value = func(u *bumble.User) interface{} { return u.DistanceLong }
+	case "distance_short":
+		col.Value = func(u *bumble.User) interface{} { return u.DistanceShort }
+	case "location":
+		col.Value = func(u *bumble.User) interface{} { return u.Location }
+	case "scan_date":
+		col.Type = timeColumn
+		col.Value = func(u *bumble.User) interface{} { return u.ScanDate }
+	case "num_photos":
+		col.Type = intColumn
+		col.Value = func(u *bumble.User) interface{} { return len(u.AllPhotos()) }
+	default:
+		return nil
+	}
+	return col
+}
+
+func profileFieldColumn(id string) *column {
+	return &column{
+		Name:     "field_" + id,
+		Type:     stringColumn,
+		Optional: true,
+		Value: func(u *bumble.User) interface{} {
+			for _, field := range u.ProfileFields {
+				if field.ID == id {
+					return field.DisplayValue
+				}
+			}
+			return nil
+		},
+	}
+}
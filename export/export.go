@@ -0,0 +1,104 @@
+// Command export writes users from the database to a file
+// for use with other tools.
+//
+// The jsonl format writes one full User object per line.
+// The csv and parquet formats write one flat row per user,
+// with a column for each selected field and each selected
+// profile field. Users are streamed from the database, so
+// exports of any size use a bounded amount of memory.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+type userWriter interface {
+	WriteUser(u *bumble.User) error
+
+	// Close flushes any buffered output. It does not close
+	// the underlying io.Writer.
+	Close() error
+}
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var format, outPath, fields, profileFields string
+	var filter bumble.UserFilter
+	flag.StringVar(&format, "format", "jsonl", "output format: jsonl, csv, or parquet")
+	flag.StringVar(&outPath, "out", "-", "output file, or - for standard output")
+	flag.StringVar(&fields, "fields", strings.Join(columnNames, ","),
+		"comma-separated user fields to include in csv and parquet output")
+	flag.StringVar(&profileFields, "profile-fields", "",
+		"comma-separated profile field IDs to include as columns in csv and parquet output")
+	filter.AddFlags(flag.CommandLine)
+	flag.Parse()
+
+	var out io.Writer = os.Stdout
+	if outPath != "-" {
+		f, err := os.Create(outPath)
+		essentials.Must(err)
+		defer f.Close()
+		out = f
+	}
+	bufOut := bufio.NewWriter(out)
+
+	columns, err := selectColumns(splitList(fields), splitList(profileFields))
+	essentials.Must(err)
+
+	var w userWriter
+	switch format {
+	case "jsonl":
+		w = newJSONLWriter(bufOut)
+	case "csv":
+		w = newCSVWriter(bufOut, columns)
+	case "parquet":
+		w, err = newParquetWriter(bufOut, columns)
+		essentials.Must(err)
+	default:
+		essentials.Die("unknown format: " + format)
+	}
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	var count int
+	users := db.Users(ctx, &filter)
+	defer users.Close()
+	for users.Next() {
+		u := users.Value()
+		essentials.Must(w.WriteUser(u))
+		count++
+		if count%100000 == 0 {
+			log.Printf("export: wrote %d users", count)
+		}
+	}
+	essentials.Must(users.Err())
+	essentials.Must(w.Close())
+	essentials.Must(bufOut.Flush())
+	log.Printf("export: done: wrote %d users", count)
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonlWriter) WriteUser(u *bumble.User) error {
+	return j.enc.Encode(u)
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w         *csv.Writer
+	columns   []*column
+	wroteHead bool
+}
+
+func newCSVWriter(w io.Writer, columns []*column) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+func (c *csvWriter) WriteUser(u *bumble.User) error {
+	if !c.wroteHead {
+		c.wroteHead = true
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		switch val := col.Value(u).(type) {
+		case string:
+			row[i] = val
+		case int:
+			row[i] = strconv.Itoa(val)
+		case bool:
+			row[i] = strconv.FormatBool(val)
+		case time.Time:
+			row[i] = val.UTC().Format(time.RFC3339)
+		}
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Close() error {
+	if !c.wroteHead {
+		c.wroteHead = true
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) writeHeader() error {
+	header := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		header[i] = col.Name
+	}
+	return c.w.Write(header)
+}
+
+// parquetRowGroupSize limits the number of bytes of rows
+// which are buffered in memory before being written.
+const parquetRowGroupSize = 16 << 20
+
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []*column
+}
+
+func newParquetWriter(w io.Writer, columns []*column) (*parquetWriter, error) {
+	schema := make([]string, len(columns))
+	for i, col := range columns {
+		schema[i] = "name=" + col.Name + ", " + parquetType(col.Type)
+		if col.Optional {
+			schema[i] += ", repetitiontype=OPTIONAL"
+		} else {
+			schema[i] += ", repetitiontype=REQUIRED"
+		}
+	}
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	return &parquetWriter{w: pw, columns: columns}, nil
+}
+
+func (p *parquetWriter) WriteUser(u *bumble.User) error {
+	row := make([]interface{}, len(p.columns))
+	for i, col := range p.columns {
+		switch val := col.Value(u).(type) {
+		case string:
+			row[i] = val
+		case int:
+			row[i] = int64(val)
+		case bool:
+			row[i] = val
+		case time.Time:
+			row[i] = val.UnixNano() / int64(time.Millisecond)
+		}
+	}
+	return p.w.Write(row)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
+
+func parquetType(t columnType) string {
+	switch t {
+	case intColumn:
+		return "type=INT64"
+	case boolColumn:
+		return "type=BOOLEAN"
+	case timeColumn:
+		return "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+	default:
+		return "type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+}
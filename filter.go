@@ -0,0 +1,280 @@
+package bumble
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A UserFilter selects a subset of users.
+//
+// The zero value matches every user. Every non-zero field
+// adds a constraint, and a user must satisfy all of them.
+type UserFilter struct {
+	// MinAge and MaxAge are inclusive bounds on the age.
+	MinAge int
+	MaxAge int
+
+	Gender   int
+	Verified *bool
+
+	// Locations, if non-empty, lists the allowed values of
+	// the user's Location field.
+	Locations []string
+
+	// CountryCode requires that the user's location be
+	// geocoded to the given country.
+	CountryCode string
+
+	// FieldID requires that the user has a ProfileField
+	// with this ID. If FieldValue is also set, the field's
+	// DisplayValue must match it exactly.
+	FieldID    string
+	FieldValue string
+
+	// ScannedBefore, if non-zero, requires that the user's
+	// ScanDate be strictly before this time.
+	ScannedBefore time.Time
+
+	// AfterID, if non-empty, requires that the user's ID
+	// sort strictly after it. It is used as a resume token
+	// for Database.Users.
+	AfterID string
+}
+
+// Match checks if a user satisfies the filter.
+//
+// The loc argument is the geocoded version of the user's
+// Location, or nil if it has not been geocoded.
+func (f *UserFilter) Match(u *User, loc *Location) bool {
+	if f == nil {
+		return true
+	}
+	if f.MinAge != 0 && u.Age < f.MinAge {
+		return false
+	}
+	if f.MaxAge != 0 && u.Age > f.MaxAge {
+		return false
+	}
+	if f.Gender != 0 && u.Gender != f.Gender {
+		return false
+	}
+	if f.Verified != nil && u.Verified != *f.Verified {
+		return false
+	}
+	if len(f.Locations) > 0 {
+		var found bool
+		for _, l := range f.Locations {
+			if l == u.Location {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.CountryCode != "" && (loc == nil || loc.CountryCode != f.CountryCode) {
+		return false
+	}
+	if f.FieldID != "" {
+		var found bool
+		for _, field := range u.ProfileFields {
+			if field.ID == f.FieldID &&
+				(f.FieldValue == "" || field.DisplayValue == f.FieldValue) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.ScannedBefore.IsZero() && !u.ScanDate.Before(f.ScannedBefore) {
+		return false
+	}
+	if f.AfterID != "" && u.ID <= f.AfterID {
+		return false
+	}
+	return true
+}
+
+// AddFlags registers command-line flags that set the
+// fields of the filter.
+func (f *UserFilter) AddFlags(fs *flag.FlagSet) {
+	fs.IntVar(&f.MinAge, "min-age", 0, "only include users at least this old")
+	fs.IntVar(&f.MaxAge, "max-age", 0, "only include users at most this old")
+	fs.IntVar(&f.Gender, "gender", 0, "only include users of this gender (1 or 2)")
+	fs.Var(boolPtrFlag{ptr: &f.Verified}, "verified",
+		"only include users with this verified status")
+	fs.Var((*stringListFlag)(&f.Locations), "location",
+		"only include users at this location (may be repeated)")
+	fs.StringVar(&f.CountryCode, "country", "", "only include users in this country code")
+	fs.StringVar(&f.FieldID, "field", "", "only include users with this profile field ID")
+	fs.StringVar(&f.FieldValue, "field-value", "",
+		"only include users whose -field has this display value")
+	fs.StringVar(&f.AfterID, "after-id", "",
+		"only include users whose ID sorts after this one, to resume an interrupted run")
+}
+
+// mongoQuery creates a MongoDB query for the filter.
+//
+// Since the country of a user is stored in a separate
+// collection, the caller must look up the locations in
+// f.CountryCode and pass them as countryLocs.
+func (f *UserFilter) mongoQuery(countryLocs []string) bson.D {
+	if f == nil {
+		return bson.D{}
+	}
+	var conds []bson.D
+	if f.MinAge != 0 {
+		conds = append(conds, bson.D{{Key: "age", Value: bson.D{{Key: "$gte", Value: f.MinAge}}}})
+	}
+	if f.MaxAge != 0 {
+		conds = append(conds, bson.D{{Key: "age", Value: bson.D{{Key: "$lte", Value: f.MaxAge}}}})
+	}
+	if f.Gender != 0 {
+		conds = append(conds, bson.D{{Key: "gender", Value: f.Gender}})
+	}
+	if f.Verified != nil {
+		conds = append(conds, bson.D{{Key: "verified", Value: *f.Verified}})
+	}
+	if len(f.Locations) > 0 {
+		conds = append(conds, bson.D{{Key: "location", Value: bson.D{
+			{Key: "$in", Value: f.Locations},
+		}}})
+	}
+	if f.CountryCode != "" {
+		if countryLocs == nil {
+			countryLocs = []string{}
+		}
+		conds = append(conds, bson.D{{Key: "location", Value: bson.D{
+			{Key: "$in", Value: countryLocs},
+		}}})
+	}
+	if f.FieldID != "" {
+		match := bson.D{{Key: "id", Value: f.FieldID}}
+		if f.FieldValue != "" {
+			match = append(match, bson.E{Key: "displayvalue", Value: f.FieldValue})
+		}
+		conds = append(conds, bson.D{{Key: "profilefields", Value: bson.D{
+			{Key: "$elemMatch", Value: match},
+		}}})
+	}
+	if !f.ScannedBefore.IsZero() {
+		conds = append(conds, bson.D{{Key: "scandate", Value: bson.D{
+			{Key: "$lt", Value: f.ScannedBefore},
+		}}})
+	}
+	if f.AfterID != "" {
+		conds = append(conds, bson.D{{Key: "id", Value: bson.D{{Key: "$gt", Value: f.AfterID}}}})
+	}
+	if len(conds) == 0 {
+		return bson.D{}
+	} else if len(conds) == 1 {
+		return conds[0]
+	}
+	return bson.D{{Key: "$and", Value: conds}}
+}
+
+// sqlCondition creates a SQL expression for the filter,
+// for use in a WHERE clause on the profiles table.
+func (f *UserFilter) sqlCondition() (string, []interface{}) {
+	if f == nil {
+		return "1", nil
+	}
+	var conds []string
+	var args []interface{}
+	if f.MinAge != 0 {
+		conds = append(conds, "json_extract(profiles.data, '$.Age') >= ?")
+		args = append(args, f.MinAge)
+	}
+	if f.MaxAge != 0 {
+		conds = append(conds, "json_extract(profiles.data, '$.Age') <= ?")
+		args = append(args, f.MaxAge)
+	}
+	if f.Gender != 0 {
+		conds = append(conds, "json_extract(profiles.data, '$.Gender') = ?")
+		args = append(args, f.Gender)
+	}
+	if f.Verified != nil {
+		conds = append(conds, "json_extract(profiles.data, '$.Verified') = ?")
+		args = append(args, *f.Verified)
+	}
+	if len(f.Locations) > 0 {
+		conds = append(conds, "profiles.location IN ("+sqlPlaceholders(len(f.Locations))+")")
+		for _, loc := range f.Locations {
+			args = append(args, loc)
+		}
+	}
+	if f.CountryCode != "" {
+		conds = append(conds, "profiles.location IN "+
+			"(SELECT name FROM locations WHERE country_code = ?)")
+		args = append(args, f.CountryCode)
+	}
+	if f.FieldID != "" {
+		cond := "EXISTS (SELECT 1 FROM json_each(profiles.data, '$.ProfileFields') " +
+			"WHERE json_extract(value, '$.ID') = ?"
+		args = append(args, f.FieldID)
+		if f.FieldValue != "" {
+			cond += " AND json_extract(value, '$.DisplayValue') = ?"
+			args = append(args, f.FieldValue)
+		}
+		conds = append(conds, cond+")")
+	}
+	if !f.ScannedBefore.IsZero() {
+		conds = append(conds, "julianday(json_extract(profiles.data, '$.ScanDate')) < julianday(?)")
+		args = append(args, f.ScannedBefore.UTC().Format(time.RFC3339Nano))
+	}
+	if f.AfterID != "" {
+		conds = append(conds, "profiles.id > ?")
+		args = append(args, f.AfterID)
+	}
+	if len(conds) == 0 {
+		return "1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// boolPtrFlag is a flag.Value which sets an optional
+// boolean.
+type boolPtrFlag struct {
+	ptr **bool
+}
+
+func (b boolPtrFlag) String() string {
+	if b.ptr == nil || *b.ptr == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.ptr)
+}
+
+func (b boolPtrFlag) Set(s string) error {
+	val, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.ptr = &val
+	return nil
+}
+
+func (b boolPtrFlag) IsBoolFlag() bool {
+	return true
+}
+
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, "; ")
+}
+
+func (s *stringListFlag) Set(val string) error {
+	*s = append(*s, val)
+	return nil
+}
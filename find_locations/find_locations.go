@@ -17,27 +17,34 @@ import (
 )
 
 func main() {
-	db, err := bumble.OpenDatabase(bumble.GetConfig())
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+	db, err := bumble.OpenDatabase(config)
 	essentials.Must(err)
+	defer db.Close()
 
-	locs, err := db.AllUserLocations(context.Background())
+	locs, err := db.AllUserLocations(ctx)
 	essentials.Must(err)
 
 	for _, loc := range locs {
-		if _, err := db.GetLocation(loc); err == nil {
+		essentials.Must(ctx.Err())
+		if _, err := db.GetLocation(ctx, loc); err == nil {
 			continue
 		}
 		log.Println("looking up:", loc)
-		loc, err := lookupLocation(loc)
+		loc, err := lookupLocation(ctx, loc)
 		if err != nil {
 			log.Println("error:", err)
 			continue
 		}
-		essentials.Must(db.AddLocation(loc))
+		essentials.Must(db.AddLocation(ctx, loc))
 	}
 }
 
-func lookupLocation(name string) (*bumble.Location, error) {
+func lookupLocation(ctx context.Context, name string) (*bumble.Location, error) {
 	dataStr := "address=" + url.QueryEscape(name)
 	body := bytes.NewReader([]byte(dataStr))
 	req, err := http.NewRequest("POST", "https://www.mapdevelopers.com/data.php?operation=geocode",
@@ -47,7 +54,7 @@ func lookupLocation(name string) (*bumble.Location, error) {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
 	req.Header.Set("Referer", "https://www.mapdevelopers.com/geocode_tool.php")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,122 @@
+// Command import_legacy imports the profiles and photos
+// downloaded by the Python scripts in legacy/.
+//
+// Each profiles/<id>.json file is parsed as a raw Bumble
+// user, and the modification time of the file is used as
+// the user's ScanDate. Photos of these users which were
+// saved as photos/<id>.jpg are added to the database
+// without downloading them again.
+//
+// Users go through the same minimization, redaction and
+// pseudonymization as in scan_dump, and photos are only
+// imported if the minimization policy allows them.
+//
+// Importing the same files again is harmless, so an
+// interrupted import can simply be run again.
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	var profilesDir, photosDir string
+	flag.StringVar(&profilesDir, "profiles", "profiles", "directory of legacy profile JSON files")
+	flag.StringVar(&photosDir, "photos", "photos", "directory of legacy photos")
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+	flag.StringVar(&config.PseudonymKeyFile, "pseudonym-key", config.PseudonymKeyFile,
+		"pseudonymize users with the secret key in this file")
+	flag.BoolVar(&config.Redact, "redact", config.Redact,
+		"redact phone numbers, emails and handles from profiles")
+	flag.Parse()
+
+	ingester, err := bumble.NewIngester(config)
+	essentials.Must(err)
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	listing, err := ioutil.ReadDir(profilesDir)
+	essentials.Must(err)
+
+	var numUsers, numPhotos, numFailed int
+	for _, info := range listing {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+		user, err := readUser(filepath.Join(profilesDir, info.Name()))
+		if err != nil {
+			log.Println("import_legacy:", err)
+			numFailed++
+			continue
+		}
+		user.ScanDate = info.ModTime()
+		photos := ingester.Ingest(user)
+		essentials.Must(db.AddUser(ctx, user))
+		numUsers++
+
+		for _, photo := range photos {
+			added, err := addPhoto(ctx, db, photosDir, photo)
+			essentials.Must(err)
+			if added {
+				numPhotos++
+			}
+		}
+		if numUsers%1000 == 0 {
+			log.Printf("import_legacy: imported %d users, %d photos", numUsers, numPhotos)
+		}
+	}
+	log.Printf("import_legacy: done: imported %d users, %d photos (%d profiles failed)",
+		numUsers, numPhotos, numFailed)
+}
+
+func readUser(path string) (*bumble.User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	user, err := bumble.ParseUser(f)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return user, nil
+}
+
+// addPhoto adds a legacy photo file to the database, if
+// the file exists and the photo is not already stored.
+//
+// The file is named after the photo's original ID, which
+// differs from the stored ID if users are pseudonymized.
+func addPhoto(ctx context.Context, db bumble.Database, photosDir string,
+	ingested *bumble.IngestedPhoto) (bool, error) {
+	photo := ingested.Photo
+	if exists, err := db.PhotoExists(ctx, photo.ID); err != nil || exists {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(photosDir, ingested.SourceID+".jpg"))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := db.AddPhoto(ctx, photo, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
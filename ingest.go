@@ -0,0 +1,79 @@
+package bumble
+
+import "github.com/pkg/errors"
+
+// An Ingester prepares new users for storage by applying
+// a minimization policy, redaction and pseudonymization,
+// in that order.
+//
+// Every command that stores new users should use an
+// Ingester, so that none of these steps can be skipped.
+type Ingester struct {
+	// Policy limits which parts of users are kept. A nil
+	// policy keeps everything.
+	Policy *MinimizationPolicy
+
+	// Redact enables RedactUser.
+	Redact bool
+
+	// Pseudonymizer, if non-nil, replaces identifiers with
+	// pseudonyms.
+	Pseudonymizer *Pseudonymizer
+}
+
+// NewIngester creates an Ingester for a configuration,
+// loading the pseudonymization key if one is set.
+func NewIngester(c *Config) (*Ingester, error) {
+	if err := c.Policy.Validate(); err != nil {
+		return nil, errors.Wrap(err, "new ingester")
+	}
+	res := &Ingester{Policy: c.Policy, Redact: c.Redact}
+	if c.PseudonymKeyFile != "" {
+		p, err := LoadPseudonymizer(c.PseudonymKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "new ingester")
+		}
+		res.Pseudonymizer = p
+	}
+	return res, nil
+}
+
+// An IngestedPhoto is a photo that may be stored for an
+// ingested user.
+type IngestedPhoto struct {
+	// Photo is the photo as it should be stored.
+	Photo *Photo
+
+	// SourceID and SourceURL are the ID and large URL of
+	// the photo before pseudonymization, which are needed
+	// to find the photo's data.
+	SourceID  string
+	SourceURL string
+}
+
+// Ingest modifies a user in place so that it can be
+// stored, and returns the photos that may be stored along
+// with it.
+//
+// If the policy does not keep photos, no photos are
+// returned.
+func (i *Ingester) Ingest(u *User) []*IngestedPhoto {
+	i.Policy.Apply(u)
+	if i.Redact {
+		RedactUser(u)
+	}
+	var photos []*IngestedPhoto
+	if i.Policy.AllowsPhotos() {
+		for _, photo := range u.AllPhotos() {
+			photos = append(photos, &IngestedPhoto{
+				Photo:     photo,
+				SourceID:  photo.ID,
+				SourceURL: photo.LargeURL,
+			})
+		}
+	}
+	if i.Pseudonymizer != nil {
+		i.Pseudonymizer.PseudonymizeUser(u)
+	}
+	return photos
+}
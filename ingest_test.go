@@ -0,0 +1,61 @@
+package bumble
+
+import (
+	"testing"
+)
+
+func TestIngester(t *testing.T) {
+	newUser := func() *User {
+		return &User{
+			ID:   "user1",
+			Name: "Alex",
+			Age:  30,
+			Albums: []*Album{
+				{UID: "album1", Photos: []*Photo{
+					{ID: "photo1", LargeURL: "//example.com/photo1.jpg"},
+				}},
+			},
+			ProfileFields: []*ProfileField{
+				{ID: "aboutme_text", Name: "About Alex", DisplayValue: "call 215-555-1234"},
+			},
+		}
+	}
+	pseudonymizer, err := NewPseudonymizer([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Everything", func(t *testing.T) {
+		ingester := &Ingester{Redact: true, Pseudonymizer: pseudonymizer}
+		u := newUser()
+		photos := ingester.Ingest(u)
+		if u.ID != pseudonymizer.UserID("user1") || u.Name != "" {
+			t.Errorf("user was not pseudonymized: %+v", u)
+		}
+		if u.ProfileFields[0].DisplayValue != "call <PHONE>" {
+			t.Errorf("user was not redacted: %q", u.ProfileFields[0].DisplayValue)
+		}
+		if len(photos) != 1 {
+			t.Fatalf("expected 1 photo but got %d", len(photos))
+		}
+		photo := photos[0]
+		if photo.Photo != u.Albums[0].Photos[0] || photo.Photo.ID != pseudonymizer.PhotoID("photo1") {
+			t.Errorf("unexpected stored photo: %+v", photo.Photo)
+		}
+		if photo.SourceID != "photo1" || photo.SourceURL != "//example.com/photo1.jpg" {
+			t.Errorf("unexpected source: %s %s", photo.SourceID, photo.SourceURL)
+		}
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		ingester := &Ingester{Policy: &MinimizationPolicy{Fields: []string{"Age"}}}
+		u := newUser()
+		photos := ingester.Ingest(u)
+		if len(photos) != 0 || len(u.Albums) != 0 {
+			t.Errorf("photos were kept: %d %d", len(photos), len(u.Albums))
+		}
+		if u.Name != "" || u.Age != 30 || len(u.ProfileFields) != 0 {
+			t.Errorf("unexpected user: %+v", u)
+		}
+	})
+}
@@ -0,0 +1,380 @@
+package bumble
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// A UserIterator steps through a stream of users.
+//
+// Typical usage is:
+//
+//	it := db.AllUsers(ctx)
+//	defer it.Close()
+//	for it.Next() {
+//		u := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// The stream is produced in the background, so Close must
+// be called to stop it if the caller does not read it to
+// the end. Canceling the iterator's context stops it as
+// well, in which case Err reports the context's error.
+type UserIterator struct {
+	s *stream
+}
+
+func newUserIterator(ctx context.Context,
+	produce func(ctx context.Context, send func(u *User) bool) error) *UserIterator {
+	return &UserIterator{s: newStream(ctx, func(ctx context.Context,
+		send func(v interface{}) bool) error {
+		return produce(ctx, func(u *User) bool {
+			return send(u)
+		})
+	})}
+}
+
+// errorUserIterator creates a UserIterator which fails
+// with an error.
+func errorUserIterator(err error) *UserIterator {
+	return newUserIterator(context.Background(), func(ctx context.Context,
+		send func(u *User) bool) error {
+		return err
+	})
+}
+
+// Next advances to the next user, returning false at the
+// end of the stream or if an error occurs.
+func (u *UserIterator) Next() bool {
+	return u.s.next()
+}
+
+// Value gets the current user, after a call to Next
+// returned true.
+func (u *UserIterator) Value() *User {
+	res, _ := u.s.cur.(*User)
+	return res
+}
+
+// Err gets the error that ended the stream, if any.
+func (u *UserIterator) Err() error {
+	return u.s.err
+}
+
+// Close stops the stream and waits for it to finish.
+//
+// It returns the same error as Err. Stopping a stream
+// before its end is not an error, but an error which the
+// stream ran into before it was stopped is still reported.
+func (u *UserIterator) Close() error {
+	return u.s.close()
+}
+
+// Chan adapts the iterator to a pair of channels, as
+// returned by earlier versions of this package.
+//
+// The caller must either read the user channel until it
+// is closed or cancel the iterator's context. The error
+// channel receives at most one error.
+func (u *UserIterator) Chan() (<-chan *User, <-chan error) {
+	userCh := make(chan *User, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(userCh)
+		defer close(errCh)
+		defer u.Close()
+		for u.Next() {
+			select {
+			case userCh <- u.Value():
+			case <-u.s.ctx.Done():
+				errCh <- u.s.ctx.Err()
+				return
+			}
+		}
+		if err := u.Err(); err != nil {
+			errCh <- err
+		}
+	}()
+	return userCh, errCh
+}
+
+// A LocationIterator steps through a stream of locations.
+//
+// It is used like a UserIterator.
+type LocationIterator struct {
+	s *stream
+}
+
+func newLocationIterator(ctx context.Context,
+	produce func(ctx context.Context, send func(l *Location) bool) error) *LocationIterator {
+	return &LocationIterator{s: newStream(ctx, func(ctx context.Context,
+		send func(v interface{}) bool) error {
+		return produce(ctx, func(l *Location) bool {
+			return send(l)
+		})
+	})}
+}
+
+// Next advances to the next location, returning false at
+// the end of the stream or if an error occurs.
+func (l *LocationIterator) Next() bool {
+	return l.s.next()
+}
+
+// Value gets the current location, after a call to Next
+// returned true.
+func (l *LocationIterator) Value() *Location {
+	res, _ := l.s.cur.(*Location)
+	return res
+}
+
+// Err gets the error that ended the stream, if any.
+func (l *LocationIterator) Err() error {
+	return l.s.err
+}
+
+// Close stops the stream and waits for it to finish.
+func (l *LocationIterator) Close() error {
+	return l.s.close()
+}
+
+// Chan adapts the iterator to a pair of channels, like
+// UserIterator.Chan.
+func (l *LocationIterator) Chan() (<-chan *Location, <-chan error) {
+	locCh := make(chan *Location, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(locCh)
+		defer close(errCh)
+		defer l.Close()
+		for l.Next() {
+			select {
+			case locCh <- l.Value():
+			case <-l.s.ctx.Done():
+				errCh <- l.s.ctx.Err()
+				return
+			}
+		}
+		if err := l.Err(); err != nil {
+			errCh <- err
+		}
+	}()
+	return locCh, errCh
+}
+
+// A PhotoIterator steps through a stream of photo
+// metadata.
+//
+// It is used like a UserIterator.
+type PhotoIterator struct {
+	s *stream
+}
+
+func newPhotoIterator(ctx context.Context,
+	produce func(ctx context.Context, send func(p *Photo) bool) error) *PhotoIterator {
+	return &PhotoIterator{s: newStream(ctx, func(ctx context.Context,
+		send func(v interface{}) bool) error {
+		return produce(ctx, func(p *Photo) bool {
+			return send(p)
+		})
+	})}
+}
+
+// Next advances to the next photo, returning false at the
+// end of the stream or if an error occurs.
+func (p *PhotoIterator) Next() bool {
+	return p.s.next()
+}
+
+// Value gets the current photo, after a call to Next
+// returned true.
+func (p *PhotoIterator) Value() *Photo {
+	res, _ := p.s.cur.(*Photo)
+	return res
+}
+
+// Err gets the error that ended the stream, if any.
+func (p *PhotoIterator) Err() error {
+	return p.s.err
+}
+
+// Close stops the stream and waits for it to finish.
+func (p *PhotoIterator) Close() error {
+	return p.s.close()
+}
+
+// Chan adapts the iterator to a pair of channels, like
+// UserIterator.Chan.
+func (p *PhotoIterator) Chan() (<-chan *Photo, <-chan error) {
+	photoCh := make(chan *Photo, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(photoCh)
+		defer close(errCh)
+		defer p.Close()
+		for p.Next() {
+			select {
+			case photoCh <- p.Value():
+			case <-p.s.ctx.Done():
+				errCh <- p.s.ctx.Err()
+				return
+			}
+		}
+		if err := p.Err(); err != nil {
+			errCh <- err
+		}
+	}()
+	return photoCh, errCh
+}
+
+// A PhotoIDIterator steps through the IDs of the photos in
+// a PhotoStore.
+//
+// It is used like a UserIterator.
+type PhotoIDIterator struct {
+	s *stream
+}
+
+func newPhotoIDIterator(ctx context.Context,
+	produce func(ctx context.Context, send func(id string) bool) error) *PhotoIDIterator {
+	return &PhotoIDIterator{s: newStream(ctx, func(ctx context.Context,
+		send func(v interface{}) bool) error {
+		return produce(ctx, func(id string) bool {
+			return send(id)
+		})
+	})}
+}
+
+// Next advances to the next ID, returning false at the
+// end of the stream or if an error occurs.
+func (p *PhotoIDIterator) Next() bool {
+	return p.s.next()
+}
+
+// Value gets the current ID, after a call to Next
+// returned true.
+func (p *PhotoIDIterator) Value() string {
+	res, _ := p.s.cur.(string)
+	return res
+}
+
+// Err gets the error that ended the stream, if any.
+func (p *PhotoIDIterator) Err() error {
+	return p.s.err
+}
+
+// Close stops the stream and waits for it to finish.
+func (p *PhotoIDIterator) Close() error {
+	return p.s.close()
+}
+
+// stream runs a producer function in the background and
+// passes its values to the typed iterators.
+type stream struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	values chan interface{}
+	errCh  chan error
+
+	cur      interface{}
+	err      error
+	finished bool
+}
+
+// newStream starts a producer, which should call send for
+// each value and return when send returns false.
+//
+// If send returned false, the stream fails with the
+// context's error, even if the producer returns nil.
+func newStream(ctx context.Context,
+	produce func(ctx context.Context, send func(v interface{}) bool) error) *stream {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	s := &stream{
+		parent: parent,
+		ctx:    ctx,
+		cancel: cancel,
+		values: make(chan interface{}, 1),
+		errCh:  make(chan error, 1),
+	}
+	go func() {
+		defer close(s.values)
+		var interrupted bool
+		err := produce(ctx, func(v interface{}) bool {
+			if ctx.Err() != nil {
+				interrupted = true
+				return false
+			}
+			select {
+			case s.values <- v:
+				return true
+			case <-ctx.Done():
+				interrupted = true
+				return false
+			}
+		})
+		if err == nil && interrupted {
+			err = ctx.Err()
+		}
+		s.errCh <- err
+	}()
+	return s
+}
+
+func (s *stream) next() bool {
+	if s.finished {
+		return false
+	}
+	if v, ok := <-s.values; ok {
+		s.cur = v
+		return true
+	}
+	s.finish()
+	return false
+}
+
+func (s *stream) finish() {
+	s.err = <-s.errCh
+	s.cur = nil
+	s.finished = true
+	s.cancel()
+}
+
+func (s *stream) close() error {
+	if s.finished {
+		return s.err
+	}
+
+	// If the producer has already returned, its error did
+	// not come from stopping the stream.
+	var err error
+	returned := false
+	select {
+	case err = <-s.errCh:
+		returned = true
+	default:
+	}
+
+	s.cancel()
+	for range s.values {
+	}
+	if !returned {
+		err = <-s.errCh
+
+		// The cancelation caused by stopping the stream
+		// early is not reported, unless the parent context
+		// was canceled as well.
+		if errors.Cause(err) == context.Canceled && s.parent.Err() == nil {
+			err = nil
+		}
+	}
+	s.err = err
+	s.cur = nil
+	s.finished = true
+	return s.err
+}
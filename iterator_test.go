@@ -0,0 +1,272 @@
+package bumble
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestUserIteratorComplete(t *testing.T) {
+	checkNoLeaks(t, func() {
+		it := countingUsers(context.Background(), 5)
+		var ids []string
+		for it.Next() {
+			ids = append(ids, it.Value().ID)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 5 || ids[0] != "user0" || ids[4] != "user4" {
+			t.Errorf("unexpected users: %v", ids)
+		}
+		if it.Value() != nil {
+			t.Error("expected nil value at end of stream")
+		}
+		if err := it.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestUserIteratorError(t *testing.T) {
+	checkNoLeaks(t, func() {
+		it := newUserIterator(context.Background(), func(ctx context.Context,
+			send func(u *User) bool) error {
+			send(&User{ID: "user1"})
+			return errors.New("test error")
+		})
+		var count int
+		for it.Next() {
+			count++
+		}
+		if count != 1 {
+			t.Errorf("expected 1 user but got %d", count)
+		}
+		if it.Err() == nil || it.Err().Error() != "test error" {
+			t.Errorf("unexpected error: %v", it.Err())
+		}
+		if err := it.Close(); err != it.Err() {
+			t.Errorf("expected Close to return %v but got %v", it.Err(), err)
+		}
+	})
+}
+
+func TestUserIteratorClose(t *testing.T) {
+	checkNoLeaks(t, func() {
+		// An endless stream which is only stopped by Close.
+		it := countingUsers(context.Background(), -1)
+		for i := 0; i < 3; i++ {
+			if !it.Next() {
+				t.Fatal("stream ended early")
+			}
+		}
+		if err := it.Close(); err != nil {
+			t.Error(err)
+		}
+		if it.Next() {
+			t.Error("Next returned true after Close")
+		}
+		if err := it.Err(); err != nil {
+			t.Error(err)
+		}
+
+		// Closing without reading anything.
+		if err := countingUsers(context.Background(), -1).Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestUserIteratorCloseError(t *testing.T) {
+	// A producer which fails while its last value is still
+	// buffered.
+	checkNoLeaks(t, func() {
+		it := newUserIterator(context.Background(), func(ctx context.Context,
+			send func(u *User) bool) error {
+			send(&User{ID: "user1"})
+			send(&User{ID: "user2"})
+			return errors.New("test error")
+		})
+		if !it.Next() {
+			t.Fatal("stream ended early")
+		}
+		for len(it.s.errCh) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if err := it.Close(); err == nil || err.Error() != "test error" {
+			t.Errorf("expected test error but got %v", err)
+		}
+		if it.Err() == nil {
+			t.Error("expected Err to report the error")
+		}
+	})
+
+	// A producer which fails while it is being stopped.
+	checkNoLeaks(t, func() {
+		it := newUserIterator(context.Background(), func(ctx context.Context,
+			send func(u *User) bool) error {
+			for send(&User{}) {
+			}
+			return errors.New("cleanup error")
+		})
+		if !it.Next() {
+			t.Fatal("stream ended early")
+		}
+		if err := it.Close(); err == nil || err.Error() != "cleanup error" {
+			t.Errorf("expected cleanup error but got %v", err)
+		}
+	})
+
+	// A producer which wraps the cancelation.
+	checkNoLeaks(t, func() {
+		it := newUserIterator(context.Background(), func(ctx context.Context,
+			send func(u *User) bool) error {
+			for send(&User{}) {
+			}
+			return errors.Wrap(ctx.Err(), "produce")
+		})
+		if err := it.Close(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestUserIteratorCancel(t *testing.T) {
+	checkNoLeaks(t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		it := countingUsers(ctx, -1)
+		if !it.Next() {
+			t.Fatal("stream ended early")
+		}
+		cancel()
+		for it.Next() {
+		}
+		if it.Err() != context.Canceled {
+			t.Errorf("expected context.Canceled but got %v", it.Err())
+		}
+	})
+
+	// A producer which ignores the context's error.
+	checkNoLeaks(t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		it := newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+			for send(&User{}) {
+			}
+			return nil
+		})
+		cancel()
+		for it.Next() {
+		}
+		if it.Err() != context.Canceled {
+			t.Errorf("expected context.Canceled but got %v", it.Err())
+		}
+	})
+}
+
+func TestUserIteratorChan(t *testing.T) {
+	checkNoLeaks(t, func() {
+		users, errCh := countingUsers(context.Background(), 5).Chan()
+		var count int
+		for range users {
+			count++
+		}
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		if count != 5 {
+			t.Errorf("expected 5 users but got %d", count)
+		}
+	})
+
+	checkNoLeaks(t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		users, errCh := countingUsers(ctx, -1).Chan()
+		<-users
+		cancel()
+
+		// The caller may stop reading users after canceling.
+		if err := <-errCh; err != context.Canceled {
+			t.Errorf("expected context.Canceled but got %v", err)
+		}
+	})
+}
+
+func TestDatabaseIteratorLeaks(t *testing.T) {
+	db, err := OpenDatabase(&Config{DatabaseURI: "memory://"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("user%d", i)
+		user := &User{ID: id, Location: "Philadelphia, PA"}
+		if err := db.AddUser(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddPhoto(context.Background(), &Photo{ID: id}, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		loc := &Location{Name: fmt.Sprintf("Location %d", i), Lat: 40, Lon: -75}
+		if err := db.AddLocation(context.Background(), loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkNoLeaks(t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for _, it := range []interface {
+			Next() bool
+			Close() error
+		}{
+			db.AllUsers(context.Background()),
+			db.UsersAt(context.Background(), "Philadelphia, PA"),
+			db.AllPhotos(context.Background()),
+			db.AllLocations(context.Background()),
+			db.LocationsNear(ctx, 40, -75, 10),
+			RedactDatabase(db).AllUsers(ctx),
+		} {
+			if !it.Next() {
+				t.Fatal("stream was empty")
+			}
+			if err := it.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
+
+// countingUsers creates an iterator over n users, or over
+// infinitely many users if n is negative.
+func countingUsers(ctx context.Context, n int) *UserIterator {
+	return newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+		for i := 0; n < 0 || i < n; i++ {
+			if !send(&User{ID: fmt.Sprintf("user%d", i)}) {
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// checkNoLeaks runs f and fails if goroutines started by f
+// are still running shortly after it returns.
+func checkNoLeaks(t *testing.T, f func()) {
+	before := runtime.NumGoroutine()
+	f()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		after := runtime.NumGoroutine()
+		if after <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("%d goroutines leaked:\n%s", after-before, buf[:n])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
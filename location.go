@@ -3,8 +3,9 @@ package bumble
 import "math"
 
 const (
-	earthRadius = 3958.8
-	degToRad    = math.Pi / 180
+	earthRadius   = 3958.8
+	degToRad      = math.Pi / 180
+	metersPerMile = 1609.344
 )
 
 type Location struct {
@@ -12,6 +13,24 @@ type Location struct {
 	Lat         float64
 	Lon         float64
 	CountryCode string
+
+	// Point is a GeoJSON version of Lat and Lon, which is
+	// filled in by the Database for geospatial queries.
+	Point *GeoPoint `bson:",omitempty"`
+}
+
+// A GeoPoint is a GeoJSON point.
+type GeoPoint struct {
+	Type string `bson:"type"`
+
+	// Coordinates is a (longitude, latitude) pair.
+	Coordinates []float64 `bson:"coordinates"`
+}
+
+// NewGeoPoint creates a GeoJSON point for a latitude and
+// longitude.
+func NewGeoPoint(lat, lon float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lon, lat}}
 }
 
 // Distance returns the distance (in miles) between two
@@ -0,0 +1,481 @@
+package bumble
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const memoryScheme = "memory://"
+
+// memoryDatabase is a Database which keeps everything,
+// including photo data, in memory.
+//
+// It is mainly useful for tests and for small, temporary
+// datasets.
+type memoryDatabase struct {
+	config *Config
+
+	lock      sync.RWMutex
+	profiles  map[string]*User
+	history   map[string][]*User
+	photos    map[string]*Photo
+	locations map[string]*Location
+
+	store *memoryPhotoStore
+
+	schemaVersion int
+}
+
+func openMemoryDatabase(c *Config) (Database, error) {
+	return &memoryDatabase{
+		config:    c,
+		profiles:  map[string]*User{},
+		history:   map[string][]*User{},
+		photos:    map[string]*Photo{},
+		locations: map[string]*Location{},
+		store:     &memoryPhotoStore{photos: map[string][]byte{}},
+	}, nil
+}
+
+func (m *memoryDatabase) AddUser(ctx context.Context, u *User) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "add user")
+	}
+	u1, err := copyUser(u)
+	if err != nil {
+		return errors.Wrap(err, "add user")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.putUser(u1)
+	return nil
+}
+
+func (m *memoryDatabase) AddUsers(ctx context.Context, users []*User) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "add users")
+	}
+	var failures []*BulkFailure
+	copies := make([]*User, 0, len(users))
+	for i, u := range users {
+		u1, err := copyUser(u)
+		if err != nil {
+			failures = append(failures, &BulkFailure{Index: i, UserID: u.ID, Err: err})
+			continue
+		}
+		copies = append(copies, u1)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, u := range copies {
+		m.putUser(u)
+	}
+	return newBulkError(failures)
+}
+
+// putUser stores a user which the caller has copied.
+//
+// The caller must hold m.lock for writing.
+func (m *memoryDatabase) putUser(u *User) {
+	m.profiles[u.ID] = u
+	if m.config.KeepHistory {
+		m.addUserVersion(u)
+	}
+}
+
+func (m *memoryDatabase) addUserVersion(u *User) {
+	versions := m.history[u.ID]
+	idx := sort.Search(len(versions), func(i int) bool {
+		return versions[i].ScanDate.After(u.ScanDate)
+	})
+	if idx > 0 {
+		if versions[idx-1].SameProfile(u) {
+			return
+		} else if versions[idx-1].ScanDate.Equal(u.ScanDate) {
+			versions[idx-1] = u
+			return
+		}
+	}
+	versions = append(versions, nil)
+	copy(versions[idx+1:], versions[idx:])
+	versions[idx] = u
+	m.history[u.ID] = versions
+}
+
+func (m *memoryDatabase) DeleteUser(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	if _, err := DeleteUserPhotos(ctx, m, userID); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.profiles, userID)
+	delete(m.history, userID)
+	return nil
+}
+
+func (m *memoryDatabase) DeleteHistory(ctx context.Context, userID string,
+	before time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "delete history")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var kept []*User
+	for _, u := range m.history[userID] {
+		if !u.ScanDate.Before(before) {
+			kept = append(kept, u)
+		}
+	}
+	if len(kept) == 0 {
+		delete(m.history, userID)
+	} else {
+		m.history[userID] = kept
+	}
+	return nil
+}
+
+func (m *memoryDatabase) RewriteUser(ctx context.Context, userID string, f func(u *User)) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// The latest version may be shared between profiles
+	// and history, and must only be rewritten once.
+	if u, ok := m.profiles[userID]; ok {
+		f(u)
+	}
+	for _, u := range m.history[userID] {
+		if u != m.profiles[userID] {
+			f(u)
+		}
+	}
+	return nil
+}
+
+func (m *memoryDatabase) GetUser(ctx context.Context, userID string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "get user")
+	}
+	m.lock.RLock()
+	u, ok := m.profiles[userID]
+	m.lock.RUnlock()
+	if !ok {
+		return nil, errors.Wrap(ErrUserNotFound, "get user")
+	}
+	return copyUser(u)
+}
+
+func (m *memoryDatabase) UserHistory(ctx context.Context, userID string) *UserIterator {
+	if !m.config.KeepHistory {
+		return m.users(ctx, func(u *User) bool {
+			return u.ID == userID
+		})
+	}
+	m.lock.RLock()
+	versions := append([]*User{}, m.history[userID]...)
+	m.lock.RUnlock()
+	return m.streamUsers(ctx, versions)
+}
+
+// users streams the users for which f returns true.
+//
+// The f function is called while m.lock is held for
+// reading.
+func (m *memoryDatabase) users(ctx context.Context, f func(u *User) bool) *UserIterator {
+	m.lock.RLock()
+	var users []*User
+	for _, u := range m.profiles {
+		if f(u) {
+			users = append(users, u)
+		}
+	}
+	m.lock.RUnlock()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return m.streamUsers(ctx, users)
+}
+
+func (m *memoryDatabase) streamUsers(ctx context.Context, users []*User) *UserIterator {
+	return newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+		for _, u := range users {
+			u, err := copyUser(u)
+			if err != nil {
+				return err
+			}
+			if !send(u) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (m *memoryDatabase) AllUsers(ctx context.Context) *UserIterator {
+	return m.users(ctx, func(u *User) bool {
+		return true
+	})
+}
+
+func (m *memoryDatabase) Users(ctx context.Context, filter *UserFilter) *UserIterator {
+	return m.users(ctx, func(u *User) bool {
+		return filter.Match(u, m.locations[u.Location])
+	})
+}
+
+func (m *memoryDatabase) UsersAt(ctx context.Context, location string) *UserIterator {
+	return m.users(ctx, func(u *User) bool {
+		return u.Location == location
+	})
+}
+
+func (m *memoryDatabase) AllUserLocations(ctx context.Context) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	seen := map[string]bool{}
+	var res []string
+	for _, u := range m.profiles {
+		if !seen[u.Location] {
+			seen[u.Location] = true
+			res = append(res, u.Location)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (m *memoryDatabase) UsersNear(ctx context.Context, lat, lon,
+	maxDist float64) *UserIterator {
+	names, err := locationNamesNear(ctx, m, lat, lon, maxDist)
+	if err != nil {
+		return errorUserIterator(errors.Wrap(err, "get users near"))
+	}
+	nameSet := map[string]bool{}
+	for _, name := range names {
+		nameSet[name] = true
+	}
+	return m.users(ctx, func(u *User) bool {
+		return nameSet[u.Location]
+	})
+}
+
+func (m *memoryDatabase) PhotoExists(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, errors.Wrap(err, "check photo exists")
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.photos[id]
+	return ok, nil
+}
+
+func (m *memoryDatabase) AddPhoto(ctx context.Context, photo *Photo, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "add photo")
+	}
+	photoCopy := *photo
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.store.WritePhoto(photo.ID, data); err != nil {
+		return errors.Wrap(err, "add photo")
+	}
+	m.photos[photo.ID] = &photoCopy
+	return nil
+}
+
+func (m *memoryDatabase) DeletePhoto(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "delete photo")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.photos, id)
+	if err := m.store.DeletePhoto(id); err != nil {
+		return errors.Wrap(err, "delete photo")
+	}
+	return nil
+}
+
+func (m *memoryDatabase) GetPhoto(ctx context.Context, id string) (*Photo, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, "get photo")
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	photo, ok := m.photos[id]
+	if !ok {
+		return nil, nil, errors.New("get photo: no such photo")
+	}
+	data, err := m.store.ReadPhoto(id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get photo")
+	}
+	photoCopy := *photo
+	return &photoCopy, data, nil
+}
+
+func (m *memoryDatabase) AllPhotos(ctx context.Context) *PhotoIterator {
+	m.lock.RLock()
+	var photos []*Photo
+	for _, photo := range m.photos {
+		photoCopy := *photo
+		photos = append(photos, &photoCopy)
+	}
+	m.lock.RUnlock()
+	sort.Slice(photos, func(i, j int) bool {
+		return photos[i].ID < photos[j].ID
+	})
+	return newPhotoIterator(ctx, func(ctx context.Context, send func(p *Photo) bool) error {
+		for _, photo := range photos {
+			if !send(photo) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (m *memoryDatabase) AddLocation(ctx context.Context, loc *Location) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "add location")
+	}
+	locCopy := *loc
+	locCopy.Point = NewGeoPoint(loc.Lat, loc.Lon)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.locations[loc.Name] = &locCopy
+	return nil
+}
+
+func (m *memoryDatabase) GetLocation(ctx context.Context, name string) (*Location, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "get location")
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	loc, ok := m.locations[name]
+	if !ok {
+		return nil, errors.New("get location: no such location")
+	}
+	locCopy := *loc
+	return &locCopy, nil
+}
+
+func (m *memoryDatabase) AllLocations(ctx context.Context) *LocationIterator {
+	m.lock.RLock()
+	var locs []*Location
+	for _, loc := range m.locations {
+		locCopy := *loc
+		locs = append(locs, &locCopy)
+	}
+	m.lock.RUnlock()
+	sort.Slice(locs, func(i, j int) bool {
+		return locs[i].Name < locs[j].Name
+	})
+	return newLocationIterator(ctx, func(ctx context.Context, send func(l *Location) bool) error {
+		for _, loc := range locs {
+			if !send(loc) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (m *memoryDatabase) LocationsNear(ctx context.Context, lat, lon,
+	maxDist float64) *LocationIterator {
+	return locationsNear(ctx, m, lat, lon, maxDist)
+}
+
+func (m *memoryDatabase) SchemaVersion(ctx context.Context) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.schemaVersion, nil
+}
+
+func (m *memoryDatabase) SetSchemaVersion(ctx context.Context, version int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.schemaVersion = version
+	return nil
+}
+
+// memoryPhotoStore is a PhotoStore which keeps photos in
+// memory.
+type memoryPhotoStore struct {
+	lock   sync.RWMutex
+	photos map[string][]byte
+}
+
+func (m *memoryPhotoStore) WritePhoto(id string, data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.photos[id] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *memoryPhotoStore) ReadPhoto(id string) ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	data, ok := m.photos[id]
+	if !ok {
+		return nil, errors.New("read photo: no such photo: " + id)
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *memoryPhotoStore) HasPhoto(id string) (bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.photos[id]
+	return ok, nil
+}
+
+func (m *memoryPhotoStore) DeletePhoto(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.photos, id)
+	return nil
+}
+
+func (m *memoryPhotoStore) PhotoIDs(ctx context.Context) *PhotoIDIterator {
+	m.lock.RLock()
+	ids := make([]string, 0, len(m.photos))
+	for id := range m.photos {
+		ids = append(ids, id)
+	}
+	m.lock.RUnlock()
+	sort.Strings(ids)
+	return streamPhotoIDs(ctx, ids)
+}
+
+// copyUser creates a deep copy of a user, so that stored
+// users cannot be modified by callers.
+func copyUser(u *User) (*User, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	var res User
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (m *memoryDatabase) photoStore() PhotoStore {
+	return m.store
+}
+
+func (m *memoryDatabase) Close() error {
+	return nil
+}
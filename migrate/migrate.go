@@ -0,0 +1,49 @@
+// Command migrate upgrades the database to the latest
+// schema version.
+//
+// Each migration is recorded in the database once it has
+// finished, so an interrupted run can simply be restarted.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var dryRun bool
+	flag.BoolVar(&dryRun, "dry-run", false, "list pending migrations without applying them")
+	flag.Parse()
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	version, err := db.SchemaVersion(ctx)
+	essentials.Must(err)
+	log.Printf("migrate: database is at schema version %d", version)
+
+	if dryRun {
+		pending, err := bumble.PendingMigrations(ctx, db)
+		essentials.Must(err)
+		for _, m := range pending {
+			fmt.Printf("%d: %s\n", m.Version, m.Description)
+		}
+		return
+	}
+
+	essentials.Must(bumble.Migrate(ctx, db, func(m *bumble.Migration) {
+		log.Printf("migrate: applying version %d: %s", m.Version, m.Description)
+	}))
+	log.Println("migrate: done")
+}
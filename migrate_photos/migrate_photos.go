@@ -0,0 +1,66 @@
+// Command migrate_photos copies photos from one photo
+// store layout to another.
+//
+// Photos which already exist in the destination are
+// skipped, so an interrupted migration can simply be run
+// again.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var srcLayout, srcPath, dstLayout, dstPath string
+	var deleteSrc bool
+	flag.StringVar(&srcLayout, "from-layout", config.PhotoLayout, "source photo layout")
+	flag.StringVar(&srcPath, "from", config.PhotosPath, "source photo directory")
+	flag.StringVar(&dstLayout, "to-layout", "", "destination photo layout")
+	flag.StringVar(&dstPath, "to", "", "destination photo directory")
+	flag.BoolVar(&deleteSrc, "delete", false, "delete photos from the source once copied")
+	flag.Parse()
+
+	if dstLayout == "" || dstPath == "" {
+		essentials.Die("Required flags: -to-layout and -to. See -help.")
+	}
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	src, err := bumble.OpenPhotoStore(srcLayout, srcPath)
+	essentials.Must(err)
+	dst, err := bumble.OpenPhotoStore(dstLayout, dstPath)
+	essentials.Must(err)
+
+	var numCopied, numSkipped int
+	ids := src.PhotoIDs(ctx)
+	defer ids.Close()
+	for ids.Next() {
+		id := ids.Value()
+		exists, err := dst.HasPhoto(id)
+		essentials.Must(err)
+		if exists {
+			numSkipped++
+		} else {
+			data, err := src.ReadPhoto(id)
+			essentials.Must(err)
+			essentials.Must(dst.WritePhoto(id, data))
+			numCopied++
+		}
+		if deleteSrc {
+			essentials.Must(src.DeletePhoto(id))
+		}
+		if (numCopied+numSkipped)%10000 == 0 {
+			log.Printf("migrate_photos: copied %d, skipped %d", numCopied, numSkipped)
+		}
+	}
+	essentials.Must(ids.Err())
+	log.Printf("migrate_photos: done: copied %d, skipped %d", numCopied, numSkipped)
+}
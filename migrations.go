@@ -0,0 +1,137 @@
+package bumble
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// A Migration upgrades the data in a database from one
+// schema version to the next.
+//
+// Migrations must be idempotent, since a migration may be
+// interrupted and run again before the schema version is
+// updated.
+type Migration struct {
+	// Version is the schema version of the database after
+	// the migration has been applied.
+	Version int
+
+	Description string
+
+	Apply func(ctx context.Context, db Database) error
+}
+
+// Migrations lists every migration in order of version.
+var Migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "fill in the Location field of users from their profile fields",
+		Apply:       migrateUserLocations,
+	},
+	{
+		Version:     2,
+		Description: "add GeoJSON points to locations",
+		Apply:       migrateLocationPoints,
+	},
+}
+
+// PendingMigrations gets the migrations which have not yet
+// been applied to a database.
+func PendingMigrations(ctx context.Context, db Database) ([]*Migration, error) {
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "pending migrations")
+	}
+	var res []*Migration
+	for _, m := range Migrations {
+		if m.Version > version {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// Migrate applies every pending migration to a database.
+//
+// The schema version is updated after each migration, so
+// an interrupted run can be resumed by calling Migrate
+// again.
+//
+// If logFn is non-nil, it is called before each migration
+// is applied.
+func Migrate(ctx context.Context, db Database, logFn func(m *Migration)) error {
+	pending, err := PendingMigrations(ctx, db)
+	if err != nil {
+		return errors.Wrap(err, "migrate")
+	}
+	for _, m := range pending {
+		if logFn != nil {
+			logFn(m)
+		}
+		if err := m.Apply(ctx, db); err != nil {
+			return errors.Wrapf(err, "migrate to version %d", m.Version)
+		}
+		if err := db.SetSchemaVersion(ctx, m.Version); err != nil {
+			return errors.Wrap(err, "migrate")
+		}
+	}
+	return nil
+}
+
+// migrateUserLocations fills in the Location of every
+// stored version of every user, including history.
+//
+// Versions are rewritten in place rather than added
+// again, so that the migration does not create new
+// history versions.
+func migrateUserLocations(ctx context.Context, db Database) error {
+	// Collect IDs before updating anything, since some
+	// backends cannot write while a query is in progress.
+	//
+	// Every user is visited, since older versions of a
+	// user may lack a location even if the latest does not.
+	var ids []string
+	users := db.AllUsers(ctx)
+	defer users.Close()
+	for users.Next() {
+		ids = append(ids, users.Value().ID)
+	}
+	if err := users.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := db.RewriteUser(ctx, id, func(u *User) {
+			if u.Location == "" {
+				u.SetLocation()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateLocationPoints(ctx context.Context, db Database) error {
+	// AddLocation always stores the point for a location,
+	// so re-adding every location fills in missing points.
+	var locs []*Location
+	it := db.AllLocations(ctx)
+	defer it.Close()
+	for it.Next() {
+		loc := it.Value()
+		if loc.Point == nil {
+			locs = append(locs, loc)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	for _, loc := range locs {
+		if err := db.AddLocation(ctx, loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package bumble
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MinimizableFields lists the User fields which a
+// MinimizationPolicy may keep or remove.
+//
+// The ID and ScanDate are always kept, since they are
+// needed to store a user. Albums contains the user's
+// photos.
+var MinimizableFields = []string{
+	"Name",
+	"Age",
+	"Gender",
+	"Verified",
+	"DistanceLong",
+	"DistanceShort",
+	"Albums",
+	"MusicServices",
+	"Location",
+}
+
+// A MinimizationPolicy lists which parts of a user are
+// stored. Everything else is removed.
+//
+// A nil policy keeps everything.
+type MinimizationPolicy struct {
+	// Fields lists the names of the User fields to keep,
+	// from MinimizableFields.
+	Fields []string
+
+	// ProfileFields lists the IDs of the ProfileFields to
+	// keep, such as "aboutme_text".
+	ProfileFields []string
+}
+
+// ParseMinimizationPolicy creates a policy from
+// comma-separated lists of fields and profile field IDs.
+//
+// If both lists are empty, the result is nil, which keeps
+// everything.
+func ParseMinimizationPolicy(fields, profileFields string) *MinimizationPolicy {
+	p := &MinimizationPolicy{
+		Fields:        splitCommaList(fields),
+		ProfileFields: splitCommaList(profileFields),
+	}
+	if len(p.Fields) == 0 && len(p.ProfileFields) == 0 {
+		return nil
+	}
+	return p
+}
+
+// Validate checks that every field in the policy is one
+// of MinimizableFields.
+func (m *MinimizationPolicy) Validate() error {
+	if m == nil {
+		return nil
+	}
+	for _, field := range m.Fields {
+		if !containsString(MinimizableFields, field) {
+			return errors.New("validate policy: unknown field: " + field)
+		}
+	}
+	return nil
+}
+
+// AllowsPhotos checks if the policy keeps photos.
+func (m *MinimizationPolicy) AllowsPhotos() bool {
+	return m.keeps("Albums")
+}
+
+// Apply removes every part of a user which the policy
+// does not keep.
+func (m *MinimizationPolicy) Apply(u *User) {
+	if m == nil {
+		return
+	}
+	if !m.keeps("Name") {
+		// Profile field names such as "About Alex" include
+		// the user's name.
+		if u.Name != "" {
+			for _, field := range u.ProfileFields {
+				field.Name = strings.Replace(field.Name, u.Name, NamePlaceholder, -1)
+			}
+		}
+		u.Name = ""
+	}
+	if !m.keeps("Age") {
+		u.Age = 0
+	}
+	if !m.keeps("Gender") {
+		u.Gender = 0
+	}
+	if !m.keeps("Verified") {
+		u.Verified = false
+	}
+	if !m.keeps("DistanceLong") {
+		u.DistanceLong = ""
+	}
+	if !m.keeps("DistanceShort") {
+		u.DistanceShort = ""
+	}
+	if !m.keeps("Albums") {
+		u.Albums = nil
+	}
+	if !m.keeps("MusicServices") {
+		u.MusicServices = nil
+	}
+	if !m.keeps("Location") {
+		u.Location = ""
+	}
+	var fields []*ProfileField
+	for _, field := range u.ProfileFields {
+		if containsString(m.ProfileFields, field.ID) {
+			fields = append(fields, field)
+		}
+	}
+	u.ProfileFields = fields
+}
+
+func (m *MinimizationPolicy) keeps(field string) bool {
+	return m == nil || containsString(m.Fields, field)
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func splitCommaList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
@@ -0,0 +1,84 @@
+// Command minimize applies a minimization policy to the
+// users already stored in the database, including their
+// history.
+//
+// If the policy does not allow photos, the stored photos
+// of every user are deleted as well.
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var fields, profileFields string
+	var dryRun bool
+	if config.Policy != nil {
+		fields = strings.Join(config.Policy.Fields, ",")
+		profileFields = strings.Join(config.Policy.ProfileFields, ",")
+	}
+	flag.StringVar(&fields, "fields", fields, "comma-separated user fields to keep ("+
+		strings.Join(bumble.MinimizableFields, ", ")+")")
+	flag.StringVar(&profileFields, "profile-fields", profileFields,
+		"comma-separated profile field IDs to keep")
+	flag.BoolVar(&dryRun, "dry-run", false, "count the affected users without changing them")
+	flag.Parse()
+
+	policy := bumble.ParseMinimizationPolicy(fields, profileFields)
+	if policy == nil {
+		essentials.Die("No policy. Pass -fields and/or -profile-fields, or set " +
+			"BUMBLE_KEEP_FIELDS and BUMBLE_KEEP_PROFILE_FIELDS.")
+	}
+	essentials.Must(policy.Validate())
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	// Collect the users first, since modifying them while
+	// the query is running is not supported by every
+	// backend.
+	var ids []string
+	users := db.AllUsers(ctx)
+	defer users.Close()
+	for users.Next() {
+		u := users.Value()
+		ids = append(ids, u.ID)
+	}
+	essentials.Must(users.Err())
+	log.Printf("minimize: %d users", len(ids))
+	if dryRun {
+		return
+	}
+
+	var numPhotos int
+	for i, id := range ids {
+		if !policy.AllowsPhotos() {
+			n, err := bumble.DeleteUserPhotos(ctx, db, id)
+			essentials.Must(err)
+			numPhotos += n
+		}
+		essentials.Must(db.RewriteUser(ctx, id, policy.Apply))
+		if (i+1)%1000 == 0 {
+			log.Printf("minimize: rewrote %d/%d users", i+1, len(ids))
+		}
+	}
+	if !policy.AllowsPhotos() {
+		// Pack stores keep deleted photos until compacted.
+		store, err := bumble.DatabasePhotoStore(db)
+		essentials.Must(err)
+		essentials.Must(bumble.CompactPhotoStore(store))
+	}
+	log.Printf("minimize: done: rewrote %d users, deleted %d photos", len(ids), numPhotos)
+}
@@ -0,0 +1,81 @@
+package bumble
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMinimizationPolicy(t *testing.T) {
+	scanDate := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	makeUser := func() *User {
+		return &User{
+			ID:            "user1",
+			Name:          "Alex",
+			Age:           30,
+			Gender:        2,
+			Verified:      true,
+			DistanceLong:  "1 mile away",
+			DistanceShort: "1 mi",
+			Albums:        []*Album{{UID: "album1", Photos: []*Photo{{ID: "photo1"}}}},
+			MusicServices: []*MusicService{{ID: "spotify"}},
+			ProfileFields: []*ProfileField{
+				{ID: "location", Name: "Location", DisplayValue: "Philadelphia, PA"},
+				{ID: "aboutme_text", Name: "About Alex", DisplayValue: "Hi"},
+				{ID: "lifestyle_zodiak", Name: "Zodiac", DisplayValue: "Leo"},
+			},
+			ScanDate: scanDate,
+			Location: "Philadelphia, PA",
+		}
+	}
+
+	var nilPolicy *MinimizationPolicy
+	u := makeUser()
+	nilPolicy.Apply(u)
+	if !reflect.DeepEqual(u, makeUser()) {
+		t.Error("nil policy should keep everything")
+	}
+	if !nilPolicy.AllowsPhotos() {
+		t.Error("nil policy should allow photos")
+	}
+
+	policy := ParseMinimizationPolicy("Age, Gender,Location", "aboutme_text")
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if policy.AllowsPhotos() {
+		t.Error("policy should not allow photos")
+	}
+	u = makeUser()
+	policy.Apply(u)
+	expected := &User{
+		ID:     "user1",
+		Age:    30,
+		Gender: 2,
+		ProfileFields: []*ProfileField{
+			{ID: "aboutme_text", Name: "About <NAME>", DisplayValue: "Hi"},
+		},
+		ScanDate: scanDate,
+		Location: "Philadelphia, PA",
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("expected %+v but got %+v", expected, u)
+	}
+
+	policy = ParseMinimizationPolicy("Name,Albums", "")
+	if !policy.AllowsPhotos() {
+		t.Error("policy should allow photos")
+	}
+	u = makeUser()
+	policy.Apply(u)
+	if u.Name != "Alex" || len(u.Albums) != 1 || u.ProfileFields != nil || u.Age != 0 {
+		t.Errorf("unexpected result: %+v", u)
+	}
+
+	if ParseMinimizationPolicy("", " ") != nil {
+		t.Error("empty policy should be nil")
+	}
+	if err := ParseMinimizationPolicy("Age,Height", "").Validate(); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
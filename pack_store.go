@@ -0,0 +1,394 @@
+package bumble
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxPackSize   = 1 << 30
+	packIndexName = "index.jsonl"
+	packPrefix    = "pack-"
+	packSuffix    = ".dat"
+)
+
+type packIndexEntry struct {
+	Op     string `json:"op"`
+	ID     string `json:"id"`
+	Pack   int    `json:"pack,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+// packPhotoStore is a PhotoStore which appends photos to a
+// sequence of pack files.
+//
+// The location of every photo is recorded in an
+// append-only index file, which is loaded into memory
+// when the store is opened. Deleting or replacing a photo
+// only updates the index, so the old data stays in the
+// pack files until the store is compacted.
+//
+// Only one process should write to a pack store at once.
+type packPhotoStore struct {
+	dir string
+
+	lock    sync.RWMutex
+	index   map[string]*packIndexEntry
+	curPack int
+	curSize int64
+}
+
+func openPackPhotoStore(dir string) (*packPhotoStore, error) {
+	p := &packPhotoStore{dir: dir, index: map[string]*packIndexEntry{}}
+	if err := p.loadIndex(); err != nil {
+		return nil, errors.Wrap(err, "open pack store")
+	}
+	if err := p.findCurrentPack(); err != nil {
+		return nil, errors.Wrap(err, "open pack store")
+	}
+	return p, nil
+}
+
+func (p *packPhotoStore) WritePhoto(id string, data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	if p.curSize > 0 && p.curSize+int64(len(data)) > maxPackSize {
+		p.curPack++
+		p.curSize = 0
+	}
+	f, err := os.OpenFile(p.packPath(p.curPack), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	// The data must be durable before the index refers to
+	// it.
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	p.curSize = info.Size() + int64(len(data))
+
+	entry := &packIndexEntry{
+		Op:     "put",
+		ID:     id,
+		Pack:   p.curPack,
+		Offset: info.Size(),
+		Length: int64(len(data)),
+	}
+	if err := p.appendIndex(entry); err != nil {
+		return err
+	}
+	p.index[id] = entry
+	return nil
+}
+
+func (p *packPhotoStore) ReadPhoto(id string) ([]byte, error) {
+	// The lock is held while reading, since compaction may
+	// remove the pack file.
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	entry, ok := p.index[id]
+	if !ok {
+		return nil, errors.New("read photo: photo not in pack store: " + id)
+	}
+	data := make([]byte, entry.Length)
+	if err := readPackData(p.packPath(entry.Pack), entry.Offset, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (p *packPhotoStore) HasPhoto(id string) (bool, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	_, ok := p.index[id]
+	return ok, nil
+}
+
+func (p *packPhotoStore) DeletePhoto(id string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.index[id]; !ok {
+		return nil
+	}
+	if err := p.appendIndex(&packIndexEntry{Op: "delete", ID: id}); err != nil {
+		return err
+	}
+	delete(p.index, id)
+	return nil
+}
+
+func (p *packPhotoStore) PhotoIDs(ctx context.Context) *PhotoIDIterator {
+	p.lock.RLock()
+	ids := make([]string, 0, len(p.index))
+	for id := range p.index {
+		ids = append(ids, id)
+	}
+	p.lock.RUnlock()
+	sort.Strings(ids)
+	return streamPhotoIDs(ctx, ids)
+}
+
+// compact rewrites the live photos into new pack files and
+// removes the old ones, so that deleted and replaced data
+// is no longer stored.
+//
+// The new index only replaces the old one once the new
+// packs are durable, so an interrupted compaction leaves
+// the store intact. Any leftover packs are removed by the
+// next compaction.
+func (p *packPhotoStore) compact() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(p.index))
+	for id := range p.index {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	newIndex := map[string]*packIndexEntry{}
+	curPack := p.curPack + 1
+	var curSize int64
+	var packFile *os.File
+	closePack := func() error {
+		if packFile == nil {
+			return nil
+		}
+		err := packFile.Sync()
+		if closeErr := packFile.Close(); err == nil {
+			err = closeErr
+		}
+		packFile = nil
+		return err
+	}
+	defer closePack()
+	for _, id := range ids {
+		entry := p.index[id]
+		data := make([]byte, entry.Length)
+		if err := readPackData(p.packPath(entry.Pack), entry.Offset, data); err != nil {
+			return err
+		}
+		if packFile != nil && curSize+entry.Length > maxPackSize {
+			if err := closePack(); err != nil {
+				return err
+			}
+			curPack++
+			curSize = 0
+		}
+		if packFile == nil {
+			f, err := os.OpenFile(p.packPath(curPack), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			packFile = f
+		}
+		if _, err := packFile.Write(data); err != nil {
+			return err
+		}
+		newIndex[id] = &packIndexEntry{
+			Op:     "put",
+			ID:     id,
+			Pack:   curPack,
+			Offset: curSize,
+			Length: entry.Length,
+		}
+		curSize += entry.Length
+	}
+	if err := closePack(); err != nil {
+		return err
+	}
+
+	if err := p.writeIndex(ids, newIndex); err != nil {
+		return err
+	}
+	p.index = newIndex
+	p.curPack = curPack
+	p.curSize = curSize
+
+	firstPack := p.curPack + 1
+	if len(ids) > 0 {
+		firstPack = newIndex[ids[0]].Pack
+	}
+	names, err := listDirNames(p.dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		num, ok := parsePackName(name)
+		if !ok || (num >= firstPack && num <= p.curPack) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(p.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return syncDir(p.dir)
+}
+
+// writeIndex atomically replaces the index file with one
+// that contains the given entries, in order.
+func (p *packPhotoStore) writeIndex(ids []string, index map[string]*packIndexEntry) error {
+	tmpFile, err := ioutil.TempFile(p.dir, packIndexName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmpFile)
+	for _, id := range ids {
+		data, err := json.Marshal(index[id])
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filepath.Join(p.dir, packIndexName))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return syncDir(p.dir)
+}
+
+func (p *packPhotoStore) loadIndex() error {
+	path := filepath.Join(p.dir, packIndexName)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// The last entry is incomplete because we
+				// crashed while writing it.
+				return os.Truncate(path, offset)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var entry packIndexEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("corrupt index entry at offset %d", offset)
+		}
+		switch entry.Op {
+		case "put":
+			p.index[entry.ID] = &entry
+		case "delete":
+			delete(p.index, entry.ID)
+		default:
+			return fmt.Errorf("unknown index operation at offset %d: %s", offset, entry.Op)
+		}
+		offset += int64(len(line))
+	}
+}
+
+func (p *packPhotoStore) findCurrentPack() error {
+	names, err := listDirNames(p.dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		num, ok := parsePackName(name)
+		if !ok || num < p.curPack {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(p.dir, name))
+		if err != nil {
+			return err
+		}
+		p.curPack = num
+		p.curSize = info.Size()
+	}
+	return nil
+}
+
+func (p *packPhotoStore) appendIndex(entry *packIndexEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(p.dir, packIndexName),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// parsePackName gets the number of a pack file from its
+// name, if it is the name of a pack file.
+func parsePackName(name string) (int, bool) {
+	if !strings.HasPrefix(name, packPrefix) || !strings.HasSuffix(name, packSuffix) {
+		return 0, false
+	}
+	numStr := strings.TrimSuffix(strings.TrimPrefix(name, packPrefix), packSuffix)
+	num, err := strconv.Atoi(numStr)
+	if err != nil {
+		return 0, false
+	}
+	return num, true
+}
+
+func readPackData(path string, offset int64, data []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(data, offset)
+	return err
+}
+
+func (p *packPhotoStore) packPath(num int) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%s%06d%s", packPrefix, num, packSuffix))
+}
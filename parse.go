@@ -0,0 +1,167 @@
+package bumble
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ParseEncounters parses the response to a
+// SERVER_GET_ENCOUNTERS request.
+//
+// If the server responded with an error message, it is
+// returned as an error.
+//
+// The ScanDate of the resulting users is not set, since
+// the time of the scan is not part of the response.
+func ParseEncounters(r io.Reader) ([]*User, error) {
+	var responseObj struct {
+		Body []struct {
+			ServerErrorMessage *struct {
+				ErrorMessage string `json:"error_message"`
+			} `json:"server_error_message"`
+
+			ClientEncounters struct {
+				Results []struct {
+					User rawUser `json:"user"`
+				} `json:"results"`
+			} `json:"client_encounters"`
+		} `json:"body"`
+	}
+	if err := json.NewDecoder(r).Decode(&responseObj); err != nil {
+		return nil, errors.Wrap(err, "parse encounters")
+	}
+
+	var users []*User
+	for _, body := range responseObj.Body {
+		if body.ServerErrorMessage != nil {
+			return nil, errors.New(body.ServerErrorMessage.ErrorMessage)
+		}
+		for _, result := range body.ClientEncounters.Results {
+			users = append(users, result.User.User())
+		}
+	}
+	return users, nil
+}
+
+// ParseUser parses a single user object, as found in the
+// results of a SERVER_GET_ENCOUNTERS response.
+//
+// As with ParseEncounters, the ScanDate is not set.
+func ParseUser(r io.Reader) (*User, error) {
+	var raw rawUser
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, errors.Wrap(err, "parse user")
+	}
+	return raw.User(), nil
+}
+
+// rawUser is the JSON representation of a user in the
+// Bumble API.
+type rawUser struct {
+	UserID        string `json:"user_id"`
+	Name          string `json:"name"`
+	Age           int    `json:"age"`
+	Gender        int    `json:"gender"`
+	Verified      bool   `json:"is_verified"`
+	DistanceLong  string `json:"distance_long"`
+	DistanceShort string `json:"distance_short"`
+	Albums        []struct {
+		UID     string `json:"uid"`
+		Name    string `json:"name"`
+		Caption string `json:"caption"`
+		Photos  []struct {
+			ID             string `json:"id"`
+			PreviewURL     string `json:"preview_url"`
+			LargeURL       string `json:"large_url"`
+			LargePhotoSize struct {
+				Width  int `json:"width"`
+				Height int `json:"height"`
+			} `json:"large_photo_size"`
+			FaceTopLeft struct {
+				X int `json:"x"`
+				Y int `json:"y"`
+			} `json:"face_top_left"`
+			FaceBottomRight struct {
+				X int `json:"x"`
+				Y int `json:"y"`
+			} `json:"face_bottom_right"`
+		} `json:"photos"`
+	} `json:"albums"`
+	MusicServices []struct {
+		Status           int `json:"status"`
+		ExternalProvider struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+			Type        int    `json:"type"`
+		} `json:"external_provider"`
+		TopArtists []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"top_artists"`
+	} `json:"music_services"`
+	ProfileFields []struct {
+		ID           string `json:"id"`
+		Type         int    `json:"type"`
+		Name         string `json:"name"`
+		DisplayValue string `json:"display_value"`
+	} `json:"profile_fields"`
+}
+
+// User converts the raw user into a *User, including the
+// Location field.
+func (r *rawUser) User() *User {
+	user := &User{
+		ID:            r.UserID,
+		Name:          r.Name,
+		Age:           r.Age,
+		Gender:        r.Gender,
+		Verified:      r.Verified,
+		DistanceLong:  r.DistanceLong,
+		DistanceShort: r.DistanceShort,
+	}
+	for _, rawAlbum := range r.Albums {
+		album := &Album{
+			UID:     rawAlbum.UID,
+			Name:    rawAlbum.Name,
+			Caption: rawAlbum.Caption,
+		}
+		for _, rawPhoto := range rawAlbum.Photos {
+			album.Photos = append(album.Photos, &Photo{
+				ID:              rawPhoto.ID,
+				PreviewURL:      rawPhoto.PreviewURL,
+				LargeURL:        rawPhoto.LargeURL,
+				FaceTopLeft:     [2]int{rawPhoto.FaceTopLeft.X, rawPhoto.FaceTopLeft.Y},
+				FaceBottomRight: [2]int{rawPhoto.FaceBottomRight.X, rawPhoto.FaceBottomRight.Y},
+				Width:           rawPhoto.LargePhotoSize.Width,
+				Height:          rawPhoto.LargePhotoSize.Height,
+			})
+		}
+		user.Albums = append(user.Albums, album)
+	}
+	for _, rawMusicService := range r.MusicServices {
+		musicService := &MusicService{
+			ID:          rawMusicService.ExternalProvider.ID,
+			DisplayName: rawMusicService.ExternalProvider.DisplayName,
+			Type:        rawMusicService.ExternalProvider.Type,
+		}
+		for _, rawArtist := range rawMusicService.TopArtists {
+			musicService.TopArtists = append(musicService.TopArtists, &MusicArtist{
+				ID:   rawArtist.ID,
+				Name: rawArtist.Name,
+			})
+		}
+		user.MusicServices = append(user.MusicServices, musicService)
+	}
+	for _, rawProfileField := range r.ProfileFields {
+		user.ProfileFields = append(user.ProfileFields, &ProfileField{
+			ID:           rawProfileField.ID,
+			Type:         rawProfileField.Type,
+			Name:         rawProfileField.Name,
+			DisplayValue: rawProfileField.DisplayValue,
+		})
+	}
+	user.SetLocation()
+	return user
+}
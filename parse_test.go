@@ -0,0 +1,77 @@
+package bumble
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestParseEncounters(t *testing.T) {
+	runGoldenTests(t, filepath.Join("testdata", "encounters"), func(f *os.File) (interface{}, error) {
+		return ParseEncounters(f)
+	})
+}
+
+func TestParseUser(t *testing.T) {
+	runGoldenTests(t, filepath.Join("testdata", "users"), func(f *os.File) (interface{}, error) {
+		return ParseUser(f)
+	})
+}
+
+// runGoldenTests parses every JSON fixture in a directory
+// and compares the result to the corresponding .golden
+// file.
+//
+// Run the tests with -update to rewrite the golden files.
+func runGoldenTests(t *testing.T, dir string, parse func(f *os.File) (interface{}, error)) {
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in " + dir)
+	}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var actual []byte
+			result, err := parse(f)
+			if err != nil {
+				actual = []byte("error: " + err.Error() + "\n")
+			} else {
+				actual, err = json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = append(actual, '\n')
+			}
+
+			goldenPath := strings.TrimSuffix(fixture, ".json") + ".golden"
+			if *updateGolden {
+				if err := ioutil.WriteFile(goldenPath, actual, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("output does not match %s:\n%s", goldenPath, actual)
+			}
+		})
+	}
+}
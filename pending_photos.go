@@ -0,0 +1,89 @@
+package bumble
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A photoTransactor is a Database which can record photo
+// writes that are in progress, so that writes interrupted
+// by a crash can be rolled back.
+//
+// Adding a photo happens in two phases. First, the write
+// is marked as pending and the data is durably written to
+// the PhotoStore. Then, the photo's record is stored and
+// the pending mark is removed.
+type photoTransactor interface {
+	PhotoExists(ctx context.Context, id string) (bool, error)
+
+	// beginPhoto marks a photo write as pending.
+	beginPhoto(ctx context.Context, id string) error
+
+	// commitPhoto stores a photo's record and removes its
+	// pending mark.
+	commitPhoto(ctx context.Context, photo *Photo) error
+
+	// endPhoto removes a photo's pending mark.
+	endPhoto(ctx context.Context, id string) error
+
+	// pendingPhotos lists the photos whose writes were
+	// marked as pending before the cutoff.
+	pendingPhotos(ctx context.Context, cutoff time.Time) ([]string, error)
+}
+
+// addPhotoTwoPhase adds a photo using a photoTransactor.
+//
+// If ctx is canceled partway through, the rollback may
+// fail as well, in which case the photo stays pending
+// until recoverPhotos resolves it.
+func addPhotoTwoPhase(ctx context.Context, t photoTransactor, store PhotoStore, photo *Photo,
+	data []byte) error {
+	if err := t.beginPhoto(ctx, photo.ID); err != nil {
+		return err
+	}
+	if err := store.WritePhoto(photo.ID, data); err != nil {
+		rollbackPhoto(ctx, t, store, photo.ID)
+		return err
+	}
+	if err := t.commitPhoto(ctx, photo); err != nil {
+		rollbackPhoto(ctx, t, store, photo.ID)
+		return err
+	}
+	return nil
+}
+
+// rollbackPhoto resolves a pending photo write.
+//
+// If the photo has a record, the write either replaced an
+// existing photo or got as far as storing the new record,
+// so the stored data is kept. Otherwise, the data is
+// removed.
+func rollbackPhoto(ctx context.Context, t photoTransactor, store PhotoStore, id string) error {
+	exists, err := t.PhotoExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := store.DeletePhoto(id); err != nil {
+			return err
+		}
+	}
+	return t.endPhoto(ctx, id)
+}
+
+// recoverPhotos rolls back every photo write which has
+// been pending for longer than StaleWriteTimeout.
+func recoverPhotos(ctx context.Context, t photoTransactor, store PhotoStore) error {
+	ids, err := t.pendingPhotos(ctx, time.Now().Add(-StaleWriteTimeout))
+	if err != nil {
+		return errors.Wrap(err, "recover photos")
+	}
+	for _, id := range ids {
+		if err := rollbackPhoto(ctx, t, store, id); err != nil {
+			return errors.Wrap(err, "recover photos")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package bumble
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecoverPhotos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending_photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		DatabaseURI: "sqlite://" + filepath.Join(dir, "db.sqlite"),
+		PhotosPath:  filepath.Join(dir, "photos"),
+	}
+	db, err := OpenDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB := db.(*sqliteDatabase)
+
+	err = db.AddPhoto(context.Background(), &Photo{ID: "committed"}, []byte("old data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate crashes after the data was written, both
+	// for a new photo and for a replaced photo.
+	for _, id := range []string{"uncommitted", "committed"} {
+		if err := sqlDB.beginPhoto(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+		if err := sqlDB.store.WritePhoto(id, []byte("new data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A write which is still in progress.
+	if err := sqlDB.beginPhoto(context.Background(), "in_progress"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.store.WritePhoto("in_progress", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.db.Exec("UPDATE pending_photos SET started = ? WHERE id != ?",
+		time.Now().Add(-2*StaleWriteTimeout).UnixNano(), "in_progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash while staging a file.
+	stagingDir := filepath.Join(config.PhotosPath, stagingDirName)
+	stalePath := filepath.Join(stagingDir, "photo123")
+	if err := ioutil.WriteFile(stalePath, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldTime := time.Now().Add(-2 * StaleWriteTimeout)
+	if err := os.Chtimes(stalePath, oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sqlDB = db.(*sqliteDatabase)
+
+	checkPhotoStoreContents(t, sqlDB.store, map[string]string{
+		"committed":   "new data",
+		"in_progress": "data",
+	})
+	pending, err := sqlDB.pendingPhotos(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0] != "in_progress" {
+		t.Errorf("unexpected pending photos: %v", pending)
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Error("stale staging file was not removed")
+	}
+}
+
+func TestCheckPhotosPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending_photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(&Config{
+		DatabaseURI: "sqlite://" + filepath.Join(dir, "db.sqlite"),
+		PhotosPath:  filepath.Join(dir, "photos"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sqlDB := db.(*sqliteDatabase)
+
+	// A write whose data is stored but whose record is not
+	// yet committed must not be reported as an orphan.
+	if err := sqlDB.beginPhoto(context.Background(), "in_progress"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"in_progress", "orphan"} {
+		if err := sqlDB.store.WritePhoto(id, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check, err := CheckPhotos(context.Background(), db, sqlDB.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(check.Orphans, []string{"orphan"}) {
+		t.Errorf("unexpected orphans: %v", check.Orphans)
+	}
+}
+
+func TestWritePhotoFileTempDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photo_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Photos should never be staged in the system temporary
+	// directory, which may be on another filesystem.
+	oldTmp := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", filepath.Join(dir, "nonexistent"))
+	defer os.Setenv("TMPDIR", oldTmp)
+
+	store, err := OpenPhotoStore(PhotoLayoutFlat, filepath.Join(dir, "photos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WritePhoto("photo1", []byte("data 1")); err != nil {
+		t.Fatal(err)
+	}
+	checkPhotoStoreContents(t, store, map[string]string{"photo1": "data 1"})
+}
@@ -0,0 +1,184 @@
+package bumble
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A PhotoCheck lists the inconsistencies between the photo
+// records in a Database and the data in its PhotoStore.
+//
+// Each list is sorted by photo ID.
+type PhotoCheck struct {
+	// NumRecords and NumBlobs count the photo records in
+	// the database and the photos in the store.
+	NumRecords int
+	NumBlobs   int
+
+	// Orphans are photos in the store which have no record
+	// in the database.
+	Orphans []string
+
+	// Missing are photo records whose data is not in the
+	// store.
+	Missing []string
+
+	// Undecodable are photo records whose data is not a
+	// valid JPEG.
+	Undecodable []string
+
+	// Unreadable are photo records whose data could not be
+	// read, for example because the photo key is wrong or
+	// a read failed. Since these may be temporary faults,
+	// they should not be repaired by deleting the photos.
+	Unreadable []string
+
+	// Unreferenced are photo records which do not appear in
+	// the albums of any version of any user.
+	Unreferenced []string
+}
+
+// CheckPhotos compares the photo records in db against the
+// photos in store, which should be the store that db uses,
+// as returned by DatabasePhotoStore.
+//
+// Every stored photo is read and decoded, so this may take
+// a long time for large stores.
+func CheckPhotos(ctx context.Context, db Database, store PhotoStore) (*PhotoCheck, error) {
+	records := map[string]bool{}
+	photos := db.AllPhotos(ctx)
+	defer photos.Close()
+	for photos.Next() {
+		photo := photos.Value()
+		records[photo.ID] = true
+	}
+	if err := photos.Err(); err != nil {
+		return nil, errors.Wrap(err, "check photos")
+	}
+
+	blobs := map[string]bool{}
+	ids := store.PhotoIDs(ctx)
+	defer ids.Close()
+	for ids.Next() {
+		blobs[ids.Value()] = true
+	}
+	if err := ids.Err(); err != nil {
+		return nil, errors.Wrap(err, "check photos")
+	}
+
+	referenced, err := referencedPhotos(ctx, db)
+	if err != nil {
+		return nil, errors.Wrap(err, "check photos")
+	}
+
+	res := &PhotoCheck{NumRecords: len(records), NumBlobs: len(blobs)}
+	var unrecorded []string
+	for id := range blobs {
+		if !records[id] {
+			unrecorded = append(unrecorded, id)
+		}
+	}
+	res.Orphans, err = confirmOrphans(ctx, db, unrecorded)
+	if err != nil {
+		return nil, errors.Wrap(err, "check photos")
+	}
+	for id := range records {
+		if !referenced[id] {
+			res.Unreferenced = append(res.Unreferenced, id)
+		}
+		if !blobs[id] {
+			res.Missing = append(res.Missing, id)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "check photos")
+		}
+		if _, data, err := db.GetPhoto(ctx, id); err != nil {
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(err, "check photos")
+			}
+			res.Unreadable = append(res.Unreadable, id)
+		} else if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			res.Undecodable = append(res.Undecodable, id)
+		}
+	}
+	for _, list := range [][]string{res.Orphans, res.Missing, res.Undecodable,
+		res.Unreadable, res.Unreferenced} {
+		sort.Strings(list)
+	}
+	return res, nil
+}
+
+// confirmOrphans filters out the photos which had no
+// record only because they were being written while the
+// store was listed.
+//
+// A photo's data is written before its record, while the
+// write is marked as pending. The pending marks are read
+// after the store was listed, so each such photo is either
+// still pending or has a record by now.
+func confirmOrphans(ctx context.Context, db Database, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pending := map[string]bool{}
+	if t, ok := db.(photoTransactor); ok {
+		// Writes from other machines may have started
+		// slightly ahead of the local clock.
+		pendingIDs, err := t.pendingPhotos(ctx, time.Now().Add(StaleWriteTimeout))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range pendingIDs {
+			pending[id] = true
+		}
+	}
+	var res []string
+	for _, id := range ids {
+		if pending[id] {
+			continue
+		}
+		if exists, err := db.PhotoExists(ctx, id); err != nil {
+			return nil, err
+		} else if !exists {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+// referencedPhotos finds the IDs of the photos in every
+// version of every user.
+func referencedPhotos(ctx context.Context, db Database) (map[string]bool, error) {
+	var userIDs []string
+	res := map[string]bool{}
+	users := db.AllUsers(ctx)
+	defer users.Close()
+	for users.Next() {
+		u := users.Value()
+		userIDs = append(userIDs, u.ID)
+		for _, photo := range u.AllPhotos() {
+			res[photo.ID] = true
+		}
+	}
+	if err := users.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range userIDs {
+		versions, err := collectHistory(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range versions {
+			for _, photo := range u.AllPhotos() {
+				res[photo.ID] = true
+			}
+		}
+	}
+	return res, nil
+}
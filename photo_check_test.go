@@ -0,0 +1,89 @@
+package bumble_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/bumble-dump"
+)
+
+func TestCheckPhotos(t *testing.T) {
+	dir := tempDir(t)
+	config := &bumble.Config{
+		DatabaseURI: "sqlite://" + filepath.Join(dir, "db.sqlite"),
+		PhotosPath:  filepath.Join(dir, "photos"),
+		PhotoLayout: bumble.PhotoLayoutSharded,
+		PhotoKey:    strings.Repeat("ab", 32),
+	}
+	db := openTestDatabase(t, config)
+	store, err := bumble.DatabasePhotoStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	validJPEG := buf.Bytes()
+
+	user := &bumble.User{
+		ID:       "user1",
+		ScanDate: time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC),
+		Albums: []*bumble.Album{{UID: "album1", Photos: []*bumble.Photo{
+			{ID: "good"}, {ID: "missing"}, {ID: "corrupt"}, {ID: "unreadable"},
+		}}},
+	}
+	if err := db.AddUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"good", "missing", "unused", "unreadable"} {
+		if err := db.AddPhoto(context.Background(), &bumble.Photo{ID: id}, validJPEG); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.AddPhoto(context.Background(), &bumble.Photo{ID: "corrupt"}, validJPEG[:10])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePhoto("missing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WritePhoto("orphan", validJPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite a photo without encrypting it, as if it was
+	// written with a different key.
+	rawStore, err := bumble.OpenPhotoStore(config.PhotoLayout, config.PhotosPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rawStore.WritePhoto("unreadable", validJPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := bumble.CheckPhotos(context.Background(), db, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &bumble.PhotoCheck{
+		NumRecords:   5,
+		NumBlobs:     5,
+		Orphans:      []string{"orphan"},
+		Missing:      []string{"missing"},
+		Undecodable:  []string{"corrupt"},
+		Unreadable:   []string{"unreadable"},
+		Unreferenced: []string{"unused"},
+	}
+	if !reflect.DeepEqual(check, expected) {
+		t.Errorf("expected %+v but got %+v", expected, check)
+	}
+}
@@ -0,0 +1,295 @@
+package bumble
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// PhotoKeySize is the number of bytes in a photo
+// encryption key.
+const PhotoKeySize = 32
+
+// Encrypted photos start with a header of the form
+//
+//	magic (4 bytes) | version (1 byte) | key ID (8 bytes) | nonce (12 bytes)
+//
+// followed by the AES-GCM ciphertext. The header and the
+// photo ID are authenticated as additional data, so a
+// header cannot be altered and a photo cannot be swapped
+// with another photo's file.
+const (
+	encryptedPhotoMagic   = "BPE\x00"
+	encryptedPhotoVersion = 1
+	photoKeyIDSize        = 8
+	photoNonceSize        = 12
+	encryptedHeaderSize   = len(encryptedPhotoMagic) + 1 + photoKeyIDSize + photoNonceSize
+)
+
+// ParsePhotoKey decodes a hex-encoded photo encryption
+// key. Leading and trailing whitespace is ignored.
+func ParsePhotoKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(string(bytes.TrimSpace([]byte(s))))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse photo key")
+	}
+	if len(key) != PhotoKeySize {
+		return nil, errors.Errorf("parse photo key: key must be %d bytes", PhotoKeySize)
+	}
+	return key, nil
+}
+
+// LoadPhotoKey reads a hex-encoded photo encryption key
+// from a file.
+func LoadPhotoKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "load photo key")
+	}
+	key, err := ParsePhotoKey(string(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "load photo key")
+	}
+	return key, nil
+}
+
+// NewEncryptedPhotoStore wraps a PhotoStore so that photos
+// are encrypted with key before they are written, and
+// decrypted when they are read.
+//
+// Encryption happens before the data reaches the
+// underlying store, so any layout can be used.
+func NewEncryptedPhotoStore(store PhotoStore, key []byte) (PhotoStore, error) {
+	aead, err := newPhotoAEAD(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "new encrypted photo store")
+	}
+	return &encryptedPhotoStore{
+		PhotoStore: store,
+		aead:       aead,
+		keyID:      photoKeyID(key),
+	}, nil
+}
+
+// OpenConfigPhotoStore opens the photo store described by
+// a Config, encrypting it if the Config has a photo key.
+func OpenConfigPhotoStore(c *Config) (PhotoStore, error) {
+	store, err := OpenPhotoStore(c.PhotoLayout, c.PhotosPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.PhotoEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return store, nil
+	}
+	return NewEncryptedPhotoStore(store, key)
+}
+
+type encryptedPhotoStore struct {
+	PhotoStore
+
+	aead  cipher.AEAD
+	keyID []byte
+}
+
+func (e *encryptedPhotoStore) WritePhoto(id string, data []byte) error {
+	sealed, err := sealPhoto(e.aead, e.keyID, id, data)
+	if err != nil {
+		return errors.Wrap(err, "write photo")
+	}
+	return e.PhotoStore.WritePhoto(id, sealed)
+}
+
+func (e *encryptedPhotoStore) ReadPhoto(id string) ([]byte, error) {
+	sealed, err := e.PhotoStore.ReadPhoto(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := openPhoto(e.aead, e.keyID, id, sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "read photo "+id)
+	}
+	return data, nil
+}
+
+// ReencryptStats summarizes the result of ReencryptPhotos.
+type ReencryptStats struct {
+	// Rewritten counts the photos which were re-encrypted
+	// (or decrypted).
+	Rewritten int
+
+	// Skipped counts the photos which were already in the
+	// desired form, for example because an earlier run was
+	// interrupted.
+	Skipped int
+}
+
+// ReencryptPhotos rewrites every photo in a store so that
+// it is encrypted with newKey.
+//
+// The store should be opened without encryption, for
+// example with OpenPhotoStore. Photos encrypted with oldKey
+// are decrypted first, and unencrypted photos are simply
+// encrypted, so this can also encrypt an existing store.
+// If newKey is nil, every photo is decrypted instead.
+//
+// Photos already encrypted with newKey are skipped, so an
+// interrupted run can be resumed with the same keys.
+//
+// Afterwards, the store is compacted with CompactPhotoStore
+// so that no copies of the old data remain.
+func ReencryptPhotos(ctx context.Context, store PhotoStore, oldKey,
+	newKey []byte) (*ReencryptStats, error) {
+	var oldAEAD, newAEAD cipher.AEAD
+	var oldKeyID, newKeyID []byte
+	var err error
+	if oldKey != nil {
+		if oldAEAD, err = newPhotoAEAD(oldKey); err != nil {
+			return nil, errors.Wrap(err, "reencrypt photos")
+		}
+		oldKeyID = photoKeyID(oldKey)
+	}
+	if newKey != nil {
+		if newAEAD, err = newPhotoAEAD(newKey); err != nil {
+			return nil, errors.Wrap(err, "reencrypt photos")
+		}
+		newKeyID = photoKeyID(newKey)
+	}
+
+	// Collect the IDs first, since some stores cannot be
+	// written while they are being listed.
+	var ids []string
+	idIt := store.PhotoIDs(ctx)
+	for idIt.Next() {
+		ids = append(ids, idIt.Value())
+	}
+	if err := idIt.Close(); err != nil {
+		return nil, errors.Wrap(err, "reencrypt photos")
+	}
+
+	stats := &ReencryptStats{}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return stats, errors.Wrap(err, "reencrypt photos")
+		}
+		data, err := store.ReadPhoto(id)
+		if err != nil {
+			return stats, errors.Wrap(err, "reencrypt photos")
+		}
+
+		keyID, encrypted := encryptedPhotoKeyID(data)
+		if encrypted && newKey != nil && bytes.Equal(keyID, newKeyID) {
+			stats.Skipped++
+			continue
+		} else if !encrypted && newKey == nil {
+			stats.Skipped++
+			continue
+		}
+
+		if encrypted {
+			if oldKey == nil {
+				return stats, errors.New("reencrypt photos: photo " + id +
+					" is encrypted but no old key was given")
+			}
+			data, err = openPhoto(oldAEAD, oldKeyID, id, data)
+			if err != nil {
+				return stats, errors.Wrap(err, "reencrypt photos: photo "+id)
+			}
+		}
+		if newKey != nil {
+			data, err = sealPhoto(newAEAD, newKeyID, id, data)
+			if err != nil {
+				return stats, errors.Wrap(err, "reencrypt photos: photo "+id)
+			}
+		}
+		if err := store.WritePhoto(id, data); err != nil {
+			return stats, errors.Wrap(err, "reencrypt photos")
+		}
+		stats.Rewritten++
+	}
+
+	// A pack store keeps the old data, which may be
+	// plaintext or encrypted with the old key, until it is
+	// compacted.
+	if err := CompactPhotoStore(store); err != nil {
+		return stats, errors.Wrap(err, "reencrypt photos")
+	}
+	return stats, nil
+}
+
+func newPhotoAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != PhotoKeySize {
+		return nil, errors.Errorf("photo key must be %d bytes", PhotoKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// photoKeyID computes a short identifier for a key, which
+// is stored in the header of every photo so that the key
+// used for a photo can be recognized.
+func photoKeyID(key []byte) []byte {
+	hash := sha256.Sum256(append([]byte("bumble photo key:"), key...))
+	return hash[:photoKeyIDSize]
+}
+
+func sealPhoto(aead cipher.AEAD, keyID []byte, id string, data []byte) ([]byte, error) {
+	header := make([]byte, 0, encryptedHeaderSize)
+	header = append(header, encryptedPhotoMagic...)
+	header = append(header, encryptedPhotoVersion)
+	header = append(header, keyID...)
+	nonce := make([]byte, photoNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, data, photoAdditionalData(header, id)), nil
+}
+
+func openPhoto(aead cipher.AEAD, keyID []byte, id string, sealed []byte) ([]byte, error) {
+	dataKeyID, ok := encryptedPhotoKeyID(sealed)
+	if !ok {
+		return nil, errors.New("photo is not encrypted")
+	}
+	if !bytes.Equal(dataKeyID, keyID) {
+		return nil, errors.New("photo is encrypted with a different key")
+	}
+	header := sealed[:encryptedHeaderSize]
+	nonce := header[encryptedHeaderSize-photoNonceSize:]
+	data, err := aead.Open(nil, nonce, sealed[encryptedHeaderSize:],
+		photoAdditionalData(header, id))
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt photo")
+	}
+	return data, nil
+}
+
+// encryptedPhotoKeyID gets the key ID from the header of an
+// encrypted photo, or returns false if the data is not an
+// encrypted photo.
+func encryptedPhotoKeyID(data []byte) ([]byte, bool) {
+	if len(data) < encryptedHeaderSize ||
+		string(data[:len(encryptedPhotoMagic)]) != encryptedPhotoMagic ||
+		data[len(encryptedPhotoMagic)] != encryptedPhotoVersion {
+		return nil, false
+	}
+	start := len(encryptedPhotoMagic) + 1
+	return data[start : start+photoKeyIDSize], true
+}
+
+func photoAdditionalData(header []byte, id string) []byte {
+	return append(append([]byte{}, header...), id...)
+}
@@ -0,0 +1,216 @@
+package bumble
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testPhotoKey1 = bytes.Repeat([]byte{1}, PhotoKeySize)
+	testPhotoKey2 = bytes.Repeat([]byte{2}, PhotoKeySize)
+)
+
+func TestEncryptedPhotoStores(t *testing.T) {
+	for _, layout := range []string{PhotoLayoutFlat, PhotoLayoutSharded, PhotoLayoutPack} {
+		t.Run(layout, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "photo_store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			raw, err := OpenPhotoStore(layout, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store, err := NewEncryptedPhotoStore(raw, testPhotoKey1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testPhotoStore(t, store)
+
+			// The underlying data should not be readable.
+			data, err := raw.ReadPhoto("photo2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte("data 2")) {
+				t.Error("photo is stored in plaintext")
+			}
+
+			// A different key cannot read the photos.
+			other, err := NewEncryptedPhotoStore(raw, testPhotoKey2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := other.ReadPhoto("photo2"); err == nil {
+				t.Error("expected error reading with the wrong key")
+			}
+		})
+	}
+}
+
+func TestEncryptedPhotoStoreTampering(t *testing.T) {
+	raw := &memoryPhotoStore{photos: map[string][]byte{}}
+	store, err := NewEncryptedPhotoStore(raw, testPhotoKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WritePhoto("photo1", []byte("data 1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WritePhoto("photo2", []byte("data 2")); err != nil {
+		t.Fatal(err)
+	}
+	sealed1 := append([]byte{}, raw.photos["photo1"]...)
+	sealed2 := append([]byte{}, raw.photos["photo2"]...)
+
+	// Every photo should get its own nonce.
+	if bytes.Equal(sealed1[:encryptedHeaderSize], sealed2[:encryptedHeaderSize]) {
+		t.Error("photos share a header")
+	}
+
+	// Swapping the files of two photos is detected.
+	raw.photos["photo1"] = sealed2
+	if _, err := store.ReadPhoto("photo1"); err == nil {
+		t.Error("expected error reading swapped photo")
+	}
+
+	// Modifying the header or the ciphertext is detected.
+	for _, idx := range []int{len(encryptedPhotoMagic), encryptedHeaderSize - 1,
+		len(sealed1) - 1} {
+		modified := append([]byte{}, sealed1...)
+		modified[idx] ^= 1
+		raw.photos["photo1"] = modified
+		if _, err := store.ReadPhoto("photo1"); err == nil {
+			t.Errorf("expected error after modifying byte %d", idx)
+		}
+	}
+
+	// Unencrypted photos cannot be read.
+	raw.photos["photo1"] = []byte("data 1")
+	if _, err := store.ReadPhoto("photo1"); err == nil {
+		t.Error("expected error reading unencrypted photo")
+	}
+}
+
+func TestReencryptPhotos(t *testing.T) {
+	ctx := context.Background()
+	raw := &memoryPhotoStore{photos: map[string][]byte{}}
+	raw.WritePhoto("photo1", []byte("data 1"))
+	raw.WritePhoto("photo2", []byte("data 2"))
+
+	// Encrypt an unencrypted store.
+	stats, err := ReencryptPhotos(ctx, raw, nil, testPhotoKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rewritten != 2 || stats.Skipped != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	store1, err := NewEncryptedPhotoStore(raw, testPhotoKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPhotoStoreContents(t, store1, map[string]string{
+		"photo1": "data 1",
+		"photo2": "data 2",
+	})
+
+	// Simulate an interrupted rotation, where one photo has
+	// already been rotated.
+	store2, err := NewEncryptedPhotoStore(raw, testPhotoKey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store2.WritePhoto("photo1", []byte("data 1")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = ReencryptPhotos(ctx, raw, testPhotoKey1, testPhotoKey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rewritten != 1 || stats.Skipped != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	checkPhotoStoreContents(t, store2, map[string]string{
+		"photo1": "data 1",
+		"photo2": "data 2",
+	})
+
+	// Encrypted photos need the old key.
+	if _, err := ReencryptPhotos(ctx, raw, nil, testPhotoKey1); err == nil {
+		t.Error("expected error without the old key")
+	}
+
+	// Decrypt the store.
+	stats, err = ReencryptPhotos(ctx, raw, testPhotoKey2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rewritten != 2 || stats.Skipped != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	checkPhotoStoreContents(t, raw, map[string]string{
+		"photo1": "data 1",
+		"photo2": "data 2",
+	})
+}
+
+func TestParsePhotoKey(t *testing.T) {
+	key, err := ParsePhotoKey(" 0101010101010101010101010101010101010101010101010101010101010101\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testPhotoKey1) {
+		t.Errorf("unexpected key: %x", key)
+	}
+	for _, bad := range []string{"", "0102", "not hex"} {
+		if _, err := ParsePhotoKey(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestReencryptPhotosPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photo_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	raw, err := OpenPhotoStore(PhotoLayoutPack, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.WritePhoto("photo1", []byte("plaintext data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReencryptPhotos(context.Background(), raw, nil, testPhotoKey1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plaintext must not remain in any pack file.
+	names, err := listDirNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("plaintext data")) {
+			t.Errorf("%s contains the plaintext", name)
+		}
+	}
+	store, err := NewEncryptedPhotoStore(raw, testPhotoKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPhotoStoreContents(t, store, map[string]string{"photo1": "plaintext data"})
+}
@@ -0,0 +1,109 @@
+// Command photo_fsck checks that the photo records in the
+// database are consistent with the photo store.
+//
+// It reports orphaned photo files, records with missing
+// files, photos which are not valid JPEGs, and photos which
+// no user references. Each class of problem can optionally
+// be repaired by deleting the offending files or records.
+//
+// Photos which could not be read, such as when the photo
+// key is wrong, are reported but never repaired, since the
+// fault may be temporary.
+//
+// Repairs should not be made while another process writes
+// to the photo store, since pack stores only support one
+// writing process at a time.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var fixOrphans, fixMissing, fixUndecodable, fixUnreferenced, verbose bool
+	flag.BoolVar(&fixOrphans, "fix-orphans", false, "delete photo files without records")
+	flag.BoolVar(&fixMissing, "fix-missing", false, "delete records whose files are missing")
+	flag.BoolVar(&fixUndecodable, "fix-undecodable", false,
+		"delete records and files of photos which are not valid JPEGs")
+	flag.BoolVar(&fixUnreferenced, "fix-unreferenced", false,
+		"delete records and files of photos which no user references")
+	flag.BoolVar(&verbose, "v", false, "print the ID of every problematic photo")
+	flag.Parse()
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+	store, err := bumble.DatabasePhotoStore(db)
+	essentials.Must(err)
+
+	check, err := bumble.CheckPhotos(ctx, db, store)
+	essentials.Must(err)
+
+	fmt.Printf("%d records, %d files\n", check.NumRecords, check.NumBlobs)
+	report("orphaned files", check.Orphans, verbose)
+	report("missing files", check.Missing, verbose)
+	report("undecodable photos", check.Undecodable, verbose)
+	report("unreadable photos", check.Unreadable, verbose)
+	report("unreferenced photos", check.Unreferenced, verbose)
+
+	// Deleting a record also deletes its file, if any.
+	deleted := map[string]bool{}
+	deleteRecords := func(ids []string) {
+		for _, id := range ids {
+			if !deleted[id] {
+				essentials.Must(db.DeletePhoto(ctx, id))
+				deleted[id] = true
+			}
+		}
+	}
+	if fixOrphans {
+		for _, id := range check.Orphans {
+			essentials.Must(store.DeletePhoto(id))
+		}
+		log.Printf("photo_fsck: deleted %d orphaned files", len(check.Orphans))
+	}
+	if fixMissing {
+		deleteRecords(check.Missing)
+		log.Printf("photo_fsck: deleted %d records with missing files", len(check.Missing))
+	}
+	if fixUndecodable {
+		deleteRecords(check.Undecodable)
+		log.Printf("photo_fsck: deleted %d undecodable photos", len(check.Undecodable))
+	}
+	if fixUnreferenced {
+		deleteRecords(check.Unreferenced)
+		log.Printf("photo_fsck: deleted %d unreferenced photos", len(check.Unreferenced))
+	}
+	if fixOrphans || fixMissing || fixUndecodable || fixUnreferenced {
+		// Pack stores keep deleted photos until compacted.
+		essentials.Must(bumble.CompactPhotoStore(store))
+	}
+
+	// Exit with an error if any problems were left alone.
+	if (len(check.Orphans) > 0 && !fixOrphans) || (len(check.Missing) > 0 && !fixMissing) ||
+		(len(check.Undecodable) > 0 && !fixUndecodable) ||
+		(len(check.Unreferenced) > 0 && !fixUnreferenced) || len(check.Unreadable) > 0 {
+		os.Exit(1)
+	}
+}
+
+func report(name string, ids []string, verbose bool) {
+	fmt.Printf("%d %s\n", len(ids), name)
+	if verbose {
+		for _, id := range ids {
+			fmt.Println("  " + id)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package bumble
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Photo store layouts, for use with OpenPhotoStore.
+const (
+	// PhotoLayoutFlat stores every photo in one directory
+	// as <id>.jpg.
+	PhotoLayoutFlat = "flat"
+
+	// PhotoLayoutSharded stores photos in a two-level tree
+	// of directories named after the hash of the photo ID.
+	PhotoLayoutSharded = "sharded"
+
+	// PhotoLayoutPack appends photos to large pack files,
+	// keeping an index of where each photo is stored.
+	PhotoLayoutPack = "pack"
+)
+
+// StaleWriteTimeout is how long a photo write may be in
+// progress before it is assumed to have been interrupted
+// by a crash, and is rolled back.
+const StaleWriteTimeout = time.Minute
+
+// stagingDirName is the directory inside a flat or sharded
+// store where photos are written before they are moved
+// into place.
+const stagingDirName = ".staging"
+
+// A PhotoStore stores the raw data for photos, separately
+// from the photo metadata stored in a Database.
+type PhotoStore interface {
+	// WritePhoto stores the data for a photo, replacing
+	// any existing data for that photo.
+	WritePhoto(id string, data []byte) error
+
+	// ReadPhoto reads the data for a photo.
+	ReadPhoto(id string) ([]byte, error)
+
+	// HasPhoto checks if the store has data for a photo.
+	HasPhoto(id string) (bool, error)
+
+	// DeletePhoto removes the data for a photo, if it
+	// exists.
+	DeletePhoto(id string) error
+
+	// PhotoIDs streams the IDs of every stored photo.
+	PhotoIDs(ctx context.Context) *PhotoIDIterator
+}
+
+// DatabasePhotoStore gets the PhotoStore used by a
+// Database from OpenDatabase, including any encryption.
+//
+// Code which accesses the photo store directly alongside
+// the database should use this store, rather than opening
+// the store a second time.
+func DatabasePhotoStore(db Database) (PhotoStore, error) {
+	if d, ok := db.(interface{ photoStore() PhotoStore }); ok {
+		return d.photoStore(), nil
+	}
+	return nil, errors.New("database photo store: unsupported database")
+}
+
+// CompactPhotoStore removes the data of deleted and
+// replaced photos that a store still keeps on disk.
+//
+// Only pack stores keep such data, so this does nothing
+// for the other layouts. It should be run after deleting
+// photos from a pack store, and must not run while another
+// process writes to the store.
+func CompactPhotoStore(store PhotoStore) error {
+	if e, ok := store.(*encryptedPhotoStore); ok {
+		store = e.PhotoStore
+	}
+	if p, ok := store.(*packPhotoStore); ok {
+		if err := p.compact(); err != nil {
+			return errors.Wrap(err, "compact photo store")
+		}
+	}
+	return nil
+}
+
+// OpenPhotoStore opens a photo store with the given layout
+// rooted at the given directory.
+//
+// An empty layout is equivalent to PhotoLayoutFlat.
+func OpenPhotoStore(layout, path string) (PhotoStore, error) {
+	switch layout {
+	case "", PhotoLayoutFlat:
+		if err := cleanStagingDir(path); err != nil {
+			return nil, errors.Wrap(err, "open photo store")
+		}
+		return &flatPhotoStore{dir: path}, nil
+	case PhotoLayoutSharded:
+		if err := cleanStagingDir(path); err != nil {
+			return nil, errors.Wrap(err, "open photo store")
+		}
+		return &shardedPhotoStore{dir: path}, nil
+	case PhotoLayoutPack:
+		return openPackPhotoStore(path)
+	default:
+		return nil, errors.New("open photo store: unknown layout: " + layout)
+	}
+}
+
+type flatPhotoStore struct {
+	dir string
+}
+
+func (f *flatPhotoStore) WritePhoto(id string, data []byte) error {
+	return writePhotoFile(f.dir, f.path(id), data)
+}
+
+func (f *flatPhotoStore) ReadPhoto(id string) ([]byte, error) {
+	return ioutil.ReadFile(f.path(id))
+}
+
+func (f *flatPhotoStore) HasPhoto(id string) (bool, error) {
+	return photoFileExists(f.path(id))
+}
+
+func (f *flatPhotoStore) DeletePhoto(id string) error {
+	return deletePhotoFile(f.path(id))
+}
+
+func (f *flatPhotoStore) PhotoIDs(ctx context.Context) *PhotoIDIterator {
+	return newPhotoIDIterator(ctx, func(ctx context.Context, send func(id string) bool) error {
+		return listPhotoFiles(f.dir, send)
+	})
+}
+
+func (f *flatPhotoStore) path(id string) string {
+	return filepath.Join(f.dir, id+".jpg")
+}
+
+type shardedPhotoStore struct {
+	dir string
+}
+
+func (s *shardedPhotoStore) WritePhoto(id string, data []byte) error {
+	path := s.path(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writePhotoFile(s.dir, path, data)
+}
+
+func (s *shardedPhotoStore) ReadPhoto(id string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(id))
+}
+
+func (s *shardedPhotoStore) HasPhoto(id string) (bool, error) {
+	return photoFileExists(s.path(id))
+}
+
+func (s *shardedPhotoStore) DeletePhoto(id string) error {
+	return deletePhotoFile(s.path(id))
+}
+
+func (s *shardedPhotoStore) PhotoIDs(ctx context.Context) *PhotoIDIterator {
+	return newPhotoIDIterator(ctx, func(ctx context.Context, send func(id string) bool) error {
+		outer, err := listDirNames(s.dir)
+		if err != nil {
+			return err
+		}
+		for _, name1 := range outer {
+			if !isShardName(name1) {
+				continue
+			}
+			inner, err := listDirNames(filepath.Join(s.dir, name1))
+			if err != nil {
+				return err
+			}
+			for _, name2 := range inner {
+				if !isShardName(name2) {
+					continue
+				}
+				dir := filepath.Join(s.dir, name1, name2)
+				if err := listPhotoFiles(dir, send); err != nil || ctx.Err() != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *shardedPhotoStore) path(id string) string {
+	hash := sha1.Sum([]byte(id))
+	hexHash := hex.EncodeToString(hash[:])
+	return filepath.Join(s.dir, hexHash[:2], hexHash[2:4], id+".jpg")
+}
+
+func isShardName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// writePhotoFile atomically saves the data for a photo
+// to the given path inside the store directory root.
+//
+// The data is staged in a file under root, which is on the
+// same filesystem as path so that it can be renamed into
+// place. Both the file and its directory are synced, so
+// the photo is durable once this returns.
+func writePhotoFile(root, path string, data []byte) error {
+	stagingDir := filepath.Join(root, stagingDirName)
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(stagingDir, "photo")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// cleanStagingDir removes files left in the staging
+// directory of a store by interrupted writes.
+//
+// Recently modified files may belong to a write that is
+// still in progress in another process, so they are left
+// alone.
+func cleanStagingDir(root string) error {
+	stagingDir := filepath.Join(root, stagingDirName)
+	listing, err := ioutil.ReadDir(stagingDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, info := range listing {
+		if time.Since(info.ModTime()) < StaleWriteTimeout {
+			continue
+		}
+		if err := os.Remove(filepath.Join(stagingDir, info.Name())); err != nil &&
+			!os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// streamPhotoIDs iterates over a list of photo IDs.
+func streamPhotoIDs(ctx context.Context, ids []string) *PhotoIDIterator {
+	return newPhotoIDIterator(ctx, func(ctx context.Context, send func(id string) bool) error {
+		for _, id := range ids {
+			if !send(id) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func photoFileExists(path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func deletePhotoFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// listPhotoFiles sends the ID of every photo file in a
+// directory, stopping early if send returns false.
+func listPhotoFiles(dir string, send func(id string) bool) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	for {
+		names, err := f.Readdirnames(1024)
+		for _, name := range names {
+			if !strings.HasSuffix(name, ".jpg") {
+				continue
+			}
+			if !send(strings.TrimSuffix(name, ".jpg")) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func listDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
@@ -0,0 +1,216 @@
+package bumble
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPhotoStores(t *testing.T) {
+	for _, layout := range []string{PhotoLayoutFlat, PhotoLayoutSharded, PhotoLayoutPack} {
+		t.Run(layout, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "photo_store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			store, err := OpenPhotoStore(layout, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testPhotoStore(t, store)
+
+			// Make sure the data persists.
+			store, err = OpenPhotoStore(layout, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPhotoStoreContents(t, store, map[string]string{
+				"photo0": "new data",
+				"photo2": "data 2",
+				"photo3": "data 3",
+			})
+		})
+	}
+}
+
+func TestPackPhotoStoreTruncatedIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photo_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenPhotoStore(PhotoLayoutPack, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WritePhoto("photo1", []byte("data 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of writing an entry.
+	f, err := os.OpenFile(filepath.Join(dir, packIndexName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"op":"put","id":"pho`))
+	f.Close()
+
+	store, err = OpenPhotoStore(PhotoLayoutPack, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WritePhoto("photo2", []byte("data 2")); err != nil {
+		t.Fatal(err)
+	}
+	store, err = OpenPhotoStore(PhotoLayoutPack, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPhotoStoreContents(t, store, map[string]string{
+		"photo1": "data 1",
+		"photo2": "data 2",
+	})
+}
+
+func TestPackPhotoStoreCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photo_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenPhotoStore(PhotoLayoutPack, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPhotoStore(t, store)
+	if err := CompactPhotoStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deleted and replaced data must be gone.
+	listing, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packData []byte
+	for _, info := range listing {
+		if _, ok := parsePackName(info.Name()); ok {
+			data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			packData = append(packData, data...)
+		}
+	}
+	if string(packData) != "new datadata 2data 3" {
+		t.Errorf("unexpected pack data: %q", packData)
+	}
+
+	expected := map[string]string{
+		"photo0": "new data",
+		"photo2": "data 2",
+		"photo3": "data 3",
+		"photo4": "data 4",
+	}
+	if err := store.WritePhoto("photo4", []byte("data 4")); err != nil {
+		t.Fatal(err)
+	}
+	checkPhotoStoreContents(t, store, expected)
+	store, err = OpenPhotoStore(PhotoLayoutPack, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPhotoStoreContents(t, store, expected)
+
+	// Compacting an empty store removes every pack.
+	for id := range expected {
+		if err := store.DeletePhoto(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CompactPhotoStore(store); err != nil {
+		t.Fatal(err)
+	}
+	names, err := listDirNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{packIndexName}) {
+		t.Errorf("unexpected files after compaction: %v", names)
+	}
+}
+
+func testPhotoStore(t *testing.T, store PhotoStore) {
+	if has, err := store.HasPhoto("photo1"); err != nil {
+		t.Fatal(err)
+	} else if has {
+		t.Error("unexpected photo")
+	}
+	if _, err := store.ReadPhoto("photo1"); err == nil {
+		t.Error("expected error reading missing photo")
+	}
+
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("photo%d", i)
+		if err := store.WritePhoto(id, []byte(fmt.Sprintf("data %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.WritePhoto("photo0", []byte("new data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePhoto("photo1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePhoto("missing"); err != nil {
+		t.Error("deleting a missing photo should succeed, but got", err)
+	}
+
+	checkPhotoStoreContents(t, store, map[string]string{
+		"photo0": "new data",
+		"photo2": "data 2",
+		"photo3": "data 3",
+	})
+}
+
+func checkPhotoStoreContents(t *testing.T, store PhotoStore, expected map[string]string) {
+	var expectedIDs []string
+	for id, data := range expected {
+		expectedIDs = append(expectedIDs, id)
+		if has, err := store.HasPhoto(id); err != nil {
+			t.Fatal(err)
+		} else if !has {
+			t.Errorf("missing photo: %s", id)
+		}
+		actual, err := store.ReadPhoto(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != data {
+			t.Errorf("photo %s: expected %q but got %q", id, data, actual)
+		}
+	}
+
+	var ids []string
+	it := store.PhotoIDs(context.Background())
+	for it.Next() {
+		ids = append(ids, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	sort.Strings(expectedIDs)
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("expected IDs %v but got %v", expectedIDs, ids)
+	}
+}
@@ -0,0 +1,101 @@
+package bumble
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExpiredHistory describes the versions in a user's
+// history which were scanned before a retention cutoff.
+type ExpiredHistory struct {
+	UserID string
+
+	// Before is the time before which versions expire. It
+	// is the cutoff, or earlier if a version before the
+	// cutoff must be kept to stand for the latest version.
+	Before time.Time
+
+	// Versions is the number of expired versions.
+	Versions int
+
+	// PhotoIDs lists the photos which are referenced by
+	// expired versions and by no other version.
+	PhotoIDs []string
+}
+
+// FindExpiredHistory finds the versions in a user's history
+// which were scanned before cutoff.
+//
+// A version identical to the latest one is not added to
+// the history again, so the newest version scanned at or
+// before the latest one is never expired.
+func FindExpiredHistory(ctx context.Context, db Database, userID string,
+	cutoff time.Time) (*ExpiredHistory, error) {
+	res := &ExpiredHistory{UserID: userID, Before: cutoff}
+	latest, err := db.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Cause(err) == ErrUserNotFound {
+			return res, nil
+		}
+		return nil, errors.Wrap(err, "find expired history")
+	}
+	versions, err := collectHistory(ctx, db, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find expired history")
+	}
+
+	var current *User
+	for _, v := range versions {
+		if !v.ScanDate.After(latest.ScanDate) {
+			current = v
+		}
+	}
+	if current != nil && current.ScanDate.Before(cutoff) {
+		res.Before = current.ScanDate
+	}
+	var expired []*User
+	kept := []*User{latest}
+	for _, v := range versions {
+		if v.ScanDate.Before(res.Before) {
+			expired = append(expired, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	res.Versions = len(expired)
+
+	keptPhotos := map[string]bool{}
+	for _, id := range sortedPhotoIDs(kept, nil) {
+		keptPhotos[id] = true
+	}
+	res.PhotoIDs = sortedPhotoIDs(expired, keptPhotos)
+	return res, nil
+}
+
+// PruneUserHistory removes the versions in a user's
+// history which were scanned before cutoff, along with the
+// photos which only they reference.
+//
+// It returns what was removed, as found by
+// FindExpiredHistory.
+func PruneUserHistory(ctx context.Context, db Database, userID string,
+	cutoff time.Time) (*ExpiredHistory, error) {
+	expired, err := FindExpiredHistory(ctx, db, userID, cutoff)
+	if err != nil {
+		return nil, errors.Wrap(err, "prune user history")
+	}
+	if expired.Versions == 0 {
+		return expired, nil
+	}
+	// The photos are deleted first, so that an interrupted
+	// prune can be retried.
+	if _, err := deletePhotos(ctx, db, expired.PhotoIDs); err != nil {
+		return nil, errors.Wrap(err, "prune user history")
+	}
+	if err := db.DeleteHistory(ctx, userID, expired.Before); err != nil {
+		return nil, errors.Wrap(err, "prune user history")
+	}
+	return expired, nil
+}
@@ -0,0 +1,101 @@
+package bumble
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MinPseudonymKeySize is the minimum number of bytes in a
+// pseudonymization key.
+const MinPseudonymKeySize = 16
+
+// NamePlaceholder replaces a user's name wherever it is
+// removed from a profile.
+const NamePlaceholder = "<NAME>"
+
+// A Pseudonymizer replaces the identifiers in users with
+// keyed hashes of them.
+//
+// The same key always maps an identifier to the same
+// pseudonym, so pseudonymized records can still be joined
+// with each other. Without the key, a pseudonym cannot be
+// linked back to the original identifier.
+type Pseudonymizer struct {
+	key []byte
+}
+
+// NewPseudonymizer creates a Pseudonymizer with a secret
+// key.
+func NewPseudonymizer(key []byte) (*Pseudonymizer, error) {
+	if len(key) < MinPseudonymKeySize {
+		return nil, errors.Errorf("new pseudonymizer: key must be at least %d bytes",
+			MinPseudonymKeySize)
+	}
+	return &Pseudonymizer{key: append([]byte{}, key...)}, nil
+}
+
+// LoadPseudonymizer creates a Pseudonymizer using the
+// contents of a key file. Leading and trailing whitespace
+// in the file is ignored.
+func LoadPseudonymizer(path string) (*Pseudonymizer, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "load pseudonymizer")
+	}
+	p, err := NewPseudonymizer(bytes.TrimSpace(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "load pseudonymizer")
+	}
+	return p, nil
+}
+
+// UserID gets the pseudonym for a user ID.
+func (p *Pseudonymizer) UserID(id string) string {
+	return p.hash("user", id)
+}
+
+// PhotoID gets the pseudonym for a photo ID.
+func (p *Pseudonymizer) PhotoID(id string) string {
+	return p.hash("photo", id)
+}
+
+// AlbumID gets the pseudonym for an album UID.
+func (p *Pseudonymizer) AlbumID(id string) string {
+	return p.hash("album", id)
+}
+
+// PseudonymizeUser modifies a user in place, replacing
+// its identifiers with pseudonyms and removing its name.
+//
+// Photo URLs are removed as well, since they identify the
+// photo on the platform. Callers which need to download
+// photos should save the URLs first.
+func (p *Pseudonymizer) PseudonymizeUser(u *User) {
+	u.ID = p.UserID(u.ID)
+	for _, field := range u.ProfileFields {
+		if u.Name != "" {
+			field.Name = strings.Replace(field.Name, u.Name, NamePlaceholder, -1)
+		}
+	}
+	u.Name = ""
+	for _, album := range u.Albums {
+		album.UID = p.AlbumID(album.UID)
+		for _, photo := range album.Photos {
+			photo.ID = p.PhotoID(photo.ID)
+			photo.PreviewURL = ""
+			photo.LargeURL = ""
+		}
+	}
+}
+
+func (p *Pseudonymizer) hash(kind, id string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(kind + ":" + id))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
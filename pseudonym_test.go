@@ -0,0 +1,142 @@
+package bumble
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPseudonymKey = "0123456789abcdef0123456789abcdef"
+
+func TestPseudonymizerIDs(t *testing.T) {
+	p1, err := NewPseudonymizer([]byte(testPseudonymKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := NewPseudonymizer([]byte(testPseudonymKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p3, err := NewPseudonymizer([]byte("a different secret key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p1.UserID("abc") != p2.UserID("abc") {
+		t.Error("same key should give the same pseudonym")
+	}
+	if p1.UserID("abc") == p3.UserID("abc") {
+		t.Error("different keys should give different pseudonyms")
+	}
+	if p1.UserID("abc") == p1.UserID("abd") {
+		t.Error("different IDs should give different pseudonyms")
+	}
+	if p1.UserID("abc") == p1.PhotoID("abc") {
+		t.Error("user and photo pseudonyms should differ")
+	}
+	if len(p1.UserID("abc")) != 32 {
+		t.Errorf("unexpected pseudonym length: %d", len(p1.UserID("abc")))
+	}
+
+	if _, err := NewPseudonymizer([]byte("short")); err == nil {
+		t.Error("expected error for short key")
+	}
+}
+
+func TestLoadPseudonymizer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bumble-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(path, []byte(testPseudonymKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPseudonymizer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := NewPseudonymizer([]byte(testPseudonymKey))
+	if loaded.UserID("abc") != expected.UserID("abc") {
+		t.Error("trailing newline should be ignored")
+	}
+	if _, err := LoadPseudonymizer(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing key file")
+	}
+}
+
+func TestPseudonymizeUser(t *testing.T) {
+	p, _ := NewPseudonymizer([]byte(testPseudonymKey))
+	u := &User{
+		ID:   "user1",
+		Name: "Alex",
+		Age:  30,
+		Albums: []*Album{{
+			UID:    "album1",
+			Photos: []*Photo{{ID: "photo1", LargeURL: "//example.com/photo1.jpg"}},
+		}},
+		ProfileFields: []*ProfileField{
+			{ID: "aboutme_text", Name: "About Alex", DisplayValue: "I like dogs."},
+		},
+		Location: "Philadelphia, PA",
+	}
+	p.PseudonymizeUser(u)
+	expected := &User{
+		ID:  p.UserID("user1"),
+		Age: 30,
+		Albums: []*Album{{
+			UID:    p.AlbumID("album1"),
+			Photos: []*Photo{{ID: p.PhotoID("photo1")}},
+		}},
+		ProfileFields: []*ProfileField{
+			{ID: "aboutme_text", Name: "About <NAME>", DisplayValue: "I like dogs."},
+		},
+		Location: "Philadelphia, PA",
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("expected %+v but got %+v", expected, u)
+	}
+}
+
+func TestPseudonymizedWordCorrelations(t *testing.T) {
+	p, _ := NewPseudonymizer([]byte(testPseudonymKey))
+	bios := []string{"I like dogs", "I like cats", "dogs are great", "hiking and dogs"}
+
+	var results []map[string]float64
+	for _, pseudonymize := range []bool{false, true} {
+		db, err := OpenDatabase(&Config{DatabaseURI: "memory://"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, bio := range bios {
+			u := &User{
+				ID:     string(rune('a' + i)),
+				Name:   "Name",
+				Gender: 1 + i%2,
+				ProfileFields: []*ProfileField{
+					{ID: "aboutme_text", DisplayValue: bio},
+				},
+			}
+			if pseudonymize {
+				p.PseudonymizeUser(u)
+			}
+			if err := db.AddUser(context.Background(), u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		result, err := WordCorrelations(context.Background(), db, func(u *User) bool {
+			return u.Gender == 1
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("correlations differ: %v vs %v", results[0], results[1])
+	}
+}
@@ -0,0 +1,125 @@
+// Command purge deletes users, along with their history
+// and photos, who have not been scanned within the
+// retention period.
+//
+// For the other users, history versions scanned before
+// the retention period are removed, along with the photos
+// which only those versions reference.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var retentionStr string
+	var dryRun, verbose bool
+	flag.StringVar(&retentionStr, "retention", "",
+		"retention period, such as 90d or 2160h (default: BUMBLE_RETENTION)")
+	flag.BoolVar(&dryRun, "dry-run", false, "report what would be deleted without deleting it")
+	flag.BoolVar(&verbose, "v", false, "print the ID of every purged user")
+	flag.Parse()
+
+	retention := config.Retention
+	if retentionStr != "" {
+		var err error
+		retention, err = bumble.ParseRetention(retentionStr)
+		essentials.Must(err)
+	}
+	if retention <= 0 {
+		essentials.Die("No retention period. Set BUMBLE_RETENTION or pass -retention.")
+	}
+	cutoff := time.Now().Add(-retention)
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	// Collect the users first, since deleting them while
+	// the query is running is not supported by every
+	// backend.
+	var ids, rescannedIDs []string
+	users := db.AllUsers(ctx)
+	defer users.Close()
+	for users.Next() {
+		u := users.Value()
+		if u.ScanDate.Before(cutoff) {
+			ids = append(ids, u.ID)
+		} else if config.KeepHistory {
+			rescannedIDs = append(rescannedIDs, u.ID)
+		}
+	}
+	essentials.Must(users.Err())
+
+	// Count the photos of every version, all of which are
+	// deleted along with the user.
+	var numPhotos int
+	for _, id := range ids {
+		photoIDs, err := bumble.UserPhotoIDs(ctx, db, id)
+		essentials.Must(err)
+		numPhotos += len(photoIDs)
+	}
+	log.Printf("purge: %d users (with %d photos) last scanned before %s", len(ids), numPhotos,
+		cutoff.Format(time.RFC3339))
+	if verbose {
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+	}
+
+	// Users who were scanned again keep their recent
+	// history, but their expired versions are removed.
+	var expired []*bumble.ExpiredHistory
+	var numVersions, numVersionPhotos int
+	for _, id := range rescannedIDs {
+		e, err := bumble.FindExpiredHistory(ctx, db, id, cutoff)
+		essentials.Must(err)
+		if e.Versions > 0 {
+			expired = append(expired, e)
+			numVersions += e.Versions
+			numVersionPhotos += len(e.PhotoIDs)
+		}
+	}
+	log.Printf("purge: %d expired history versions (with %d photos) of %d other users",
+		numVersions, numVersionPhotos, len(expired))
+	if verbose {
+		for _, e := range expired {
+			fmt.Printf("%s (%d versions)\n", e.UserID, e.Versions)
+		}
+	}
+	if dryRun {
+		return
+	}
+
+	for i, id := range ids {
+		essentials.Must(db.DeleteUser(ctx, id))
+		if (i+1)%1000 == 0 {
+			log.Printf("purge: deleted %d/%d users", i+1, len(ids))
+		}
+	}
+	for i, e := range expired {
+		_, err := bumble.PruneUserHistory(ctx, db, e.UserID, cutoff)
+		essentials.Must(err)
+		if (i+1)%1000 == 0 {
+			log.Printf("purge: pruned %d/%d histories", i+1, len(expired))
+		}
+	}
+
+	// Pack stores keep deleted photos until compacted.
+	store, err := bumble.DatabasePhotoStore(db)
+	essentials.Must(err)
+	essentials.Must(bumble.CompactPhotoStore(store))
+	log.Printf("purge: done: deleted %d users and pruned %d histories", len(ids), len(expired))
+}
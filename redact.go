@@ -0,0 +1,168 @@
+package bumble
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Placeholders which replace personal information in
+// redacted text.
+const (
+	PhonePlaceholder  = "<PHONE>"
+	EmailPlaceholder  = "<EMAIL>"
+	HandlePlaceholder = "<HANDLE>"
+)
+
+var (
+	emailExpr = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+` +
+		`(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+
+	// obfuscatedEmailExpr matches emails written like
+	// "jane at gmail dot com" or "jane [at] gmail [dot] com".
+	obfuscatedEmailExpr = regexp.MustCompile(`(?i)[A-Za-z0-9._%+\-]+` +
+		`\s*[\[(]?\s*\bat\b\s*[\])]?\s*` +
+		`[A-Za-z0-9\-]+\s*[\[(]?\s*\bdot\b\s*[\])]?\s*(com|net|org|edu|co|io|me)\b`)
+
+	// phoneExpr matches runs of digits with the usual
+	// phone number separators. Matches with too few or too
+	// many digits are rejected by redactPhones.
+	phoneExpr = regexp.MustCompile(`(\+\s?\d{1,3}[\s.\-]?|\d[\s.\-]?)?` +
+		`(\(\d{2,4}\)|\d{2,4})[\s.\-]?\d{3,4}[\s.\-]?\d{3,4}`)
+
+	// keywordHandleExpr matches a handle introduced by the
+	// name or emoji of a social network, as in "IG: jane",
+	// "snap - jane_doe" or "👻 jane".
+	keywordHandleExpr = regexp.MustCompile(`(?i)(\b(?:ig|insta|instagram|sc|snap|snapchat)\b|` +
+		`👻|📸|📷)(\s*(?:[:=\-–—>]+|\bis\b)?\s*)(@?[A-Za-z0-9_.]*[A-Za-z0-9_])`)
+
+	// atHandleExpr matches handles like "@jane".
+	atHandleExpr = regexp.MustCompile(`(^|[^A-Za-z0-9_.@])@[A-Za-z0-9_.]*[A-Za-z0-9_]`)
+)
+
+// RedactText replaces phone numbers, email addresses and
+// social media handles in text with PhonePlaceholder,
+// EmailPlaceholder and HandlePlaceholder.
+func RedactText(text string) string {
+	text = emailExpr.ReplaceAllString(text, EmailPlaceholder)
+	text = obfuscatedEmailExpr.ReplaceAllString(text, EmailPlaceholder)
+	text = redactPhones(text)
+	text = redactKeywordHandles(text)
+	text = atHandleExpr.ReplaceAllString(text, "${1}"+HandlePlaceholder)
+	return text
+}
+
+// RedactUser applies RedactText to the value of every
+// profile field of a user.
+func RedactUser(u *User) {
+	for _, field := range u.ProfileFields {
+		field.DisplayValue = RedactText(field.DisplayValue)
+	}
+}
+
+func redactPhones(text string) string {
+	return phoneExpr.ReplaceAllStringFunc(text, func(match string) string {
+		var numDigits int
+		for _, ch := range match {
+			if ch >= '0' && ch <= '9' {
+				numDigits++
+			}
+		}
+		if numDigits < 10 || numDigits > 15 {
+			return match
+		}
+		return PhonePlaceholder
+	})
+}
+
+func redactKeywordHandles(text string) string {
+	return keywordHandleExpr.ReplaceAllStringFunc(text, func(match string) string {
+		parts := keywordHandleExpr.FindStringSubmatch(match)
+		keyword, separator, handle := parts[1], parts[2], parts[3]
+
+		// Without a separator, "ig" or "snap" may just be a
+		// word, so only handle-like tokens are redacted.
+		if strings.TrimSpace(separator) == "" && !isEmoji(keyword) &&
+			!strings.HasPrefix(handle, "@") && !strings.ContainsAny(handle, "0123456789_.") {
+			return match
+		}
+		return keyword + separator + HandlePlaceholder
+	})
+}
+
+func isEmoji(s string) bool {
+	for _, ch := range s {
+		if ch > 0x2000 {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactDatabase wraps a Database so that every user it
+// produces has been passed through RedactUser.
+//
+// This is meant for analyzing data which was stored
+// without redaction. Methods which store data are passed
+// through unchanged.
+func RedactDatabase(db Database) Database {
+	return &redactingDatabase{Database: db}
+}
+
+type redactingDatabase struct {
+	Database
+}
+
+func (r *redactingDatabase) GetUser(ctx context.Context, userID string) (*User, error) {
+	u, err := r.Database.GetUser(ctx, userID)
+	if err == nil {
+		RedactUser(u)
+	}
+	return u, err
+}
+
+func (r *redactingDatabase) UserHistory(ctx context.Context, userID string) *UserIterator {
+	return redactUsers(ctx, r.Database.UserHistory(ctx, userID))
+}
+
+func (r *redactingDatabase) AllUsers(ctx context.Context) *UserIterator {
+	return redactUsers(ctx, r.Database.AllUsers(ctx))
+}
+
+func (r *redactingDatabase) Users(ctx context.Context, filter *UserFilter) *UserIterator {
+	return redactUsers(ctx, r.Database.Users(ctx, filter))
+}
+
+func (r *redactingDatabase) UsersAt(ctx context.Context, location string) *UserIterator {
+	return redactUsers(ctx, r.Database.UsersAt(ctx, location))
+}
+
+func (r *redactingDatabase) UsersNear(ctx context.Context, lat, lon,
+	maxDist float64) *UserIterator {
+	return redactUsers(ctx, r.Database.UsersNear(ctx, lat, lon, maxDist))
+}
+
+func redactUsers(ctx context.Context, users *UserIterator) *UserIterator {
+	return newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+		defer users.Close()
+		for users.Next() {
+			u := users.Value()
+			RedactUser(u)
+			if !send(u) {
+				return nil
+			}
+		}
+		return users.Err()
+	})
+}
+
+// redactionPlaceholder finds the placeholder contained in
+// a word, or returns "" if there is none.
+func redactionPlaceholder(word string) string {
+	for _, p := range []string{PhonePlaceholder, EmailPlaceholder, HandlePlaceholder} {
+		if strings.Contains(word, p) {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package bumble
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRedactText(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Phone numbers.
+		{"text me 215-555-1234", "text me <PHONE>"},
+		{"call (215) 555-1234 anytime", "call <PHONE> anytime"},
+		{"215.555.1234", "<PHONE>"},
+		{"my number is 2155551234.", "my number is <PHONE>."},
+		{"+1 215 555 1234", "<PHONE>"},
+		{"1-215-555-1234", "<PHONE>"},
+		{"+44 20 7946 0958", "<PHONE>"},
+		{"+447946095812", "<PHONE>"},
+
+		// Emails.
+		{"email jane.doe+bumble@gmail.com!", "email <EMAIL>!"},
+		{"JANE_DOE@Example.co.uk", "<EMAIL>"},
+		{"reach me at jane at gmail dot com", "reach me at <EMAIL>"},
+		{"jane [at] yahoo [dot] com", "<EMAIL>"},
+
+		// Handles.
+		{"follow @jane.doe_", "follow <HANDLE>"},
+		{"(@jane)", "(<HANDLE>)"},
+		{"IG: jane.doe", "IG: <HANDLE>"},
+		{"ig - janedoe", "ig - <HANDLE>"},
+		{"insta: @jane_doe", "insta: <HANDLE>"},
+		{"Instagram janedoe99", "Instagram <HANDLE>"},
+		{"sc=jane_d", "sc=<HANDLE>"},
+		{"snap is janedoe", "snap is <HANDLE>"},
+		{"Snapchat: JaneDoe", "Snapchat: <HANDLE>"},
+		{"👻 jane.doe", "👻 <HANDLE>"},
+		{"📸jane", "📸<HANDLE>"},
+
+		// Several kinds at once.
+		{
+			"IG @jane, sc: janed, or 215-555-1234 / jane@x.io",
+			"IG <HANDLE>, sc: <HANDLE>, or <PHONE> / <EMAIL>",
+		},
+
+		// Text which should not be redacted.
+		{"I'm 5'11 and 25 years old", "I'm 5'11 and 25 years old"},
+		{"class of 2015, born 1995", "class of 2015, born 1995"},
+		{"I ran 26.2 miles in 3:45:12", "I ran 26.2 miles in 3:45:12"},
+		{"zip 19104", "zip 19104"},
+		{"oh snap I love big snacks", "oh snap I love big snacks"},
+		{"no ig drama please", "no ig drama please"},
+		{"meet me at the park", "meet me at the park"},
+		{"dogs @ home", "dogs @ home"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if actual := RedactText(test.input); actual != test.expected {
+			t.Errorf("RedactText(%q): expected %q but got %q", test.input, test.expected,
+				actual)
+		}
+	}
+}
+
+func TestRedactUser(t *testing.T) {
+	u := &User{
+		ID:   "user1",
+		Name: "Jane",
+		ProfileFields: []*ProfileField{
+			{ID: "aboutme_text", Name: "About Jane", DisplayValue: "snap: jane_d"},
+			{ID: "location", Name: "Location", DisplayValue: "Philadelphia, PA"},
+		},
+	}
+	RedactUser(u)
+	if u.ProfileFields[0].DisplayValue != "snap: <HANDLE>" {
+		t.Errorf("unexpected bio: %q", u.ProfileFields[0].DisplayValue)
+	}
+	if u.ProfileFields[1].DisplayValue != "Philadelphia, PA" {
+		t.Errorf("unexpected location: %q", u.ProfileFields[1].DisplayValue)
+	}
+	if u.Name != "Jane" || u.ProfileFields[0].Name != "About Jane" {
+		t.Error("names should not be redacted")
+	}
+}
+
+func TestRedactDatabase(t *testing.T) {
+	db, err := OpenDatabase(&Config{DatabaseURI: "memory://"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &User{
+		ID: "user1",
+		ProfileFields: []*ProfileField{
+			{ID: "aboutme_text", DisplayValue: "call 215-555-1234 for a good phone"},
+		},
+	}
+	if err := db.AddUser(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	redacted := RedactDatabase(db)
+
+	users := redacted.AllUsers(context.Background())
+	defer users.Close()
+	var count int
+	for users.Next() {
+		u := users.Value()
+		count++
+		words := WordsInBio(u)
+		if words[PhonePlaceholder] != 1 || words["phone"] != 1 {
+			t.Errorf("unexpected words: %v", words)
+		}
+	}
+	if err := users.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 user but got %d", count)
+	}
+
+	if got, err := redacted.GetUser(context.Background(), "user1"); err != nil {
+		t.Fatal(err)
+	} else if got.ProfileFields[0].DisplayValue != "call <PHONE> for a good phone" {
+		t.Errorf("unexpected bio: %q", got.ProfileFields[0].DisplayValue)
+	}
+
+	// The underlying data is unchanged.
+	if got, err := db.GetUser(context.Background(), "user1"); err != nil {
+		t.Fatal(err)
+	} else if got.ProfileFields[0].DisplayValue != u.ProfileFields[0].DisplayValue {
+		t.Errorf("stored bio was modified: %q", got.ProfileFields[0].DisplayValue)
+	}
+}
@@ -0,0 +1,56 @@
+// Command restore loads a backup archive produced by the
+// backup command into the database.
+//
+// The checksums of the archive are verified before
+// anything is restored. Records and photos which already
+// exist in the database are skipped, so an interrupted
+// restore can simply be run again.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var inPath string
+	flag.StringVar(&inPath, "in", "", "path to the backup archive")
+	flag.Parse()
+
+	if inPath == "" {
+		essentials.Die("Required flag: -in. See -help.")
+	}
+
+	f, err := os.Open(inPath)
+	essentials.Must(err)
+	defer f.Close()
+
+	log.Println("restore: verifying archive...")
+	manifest, err := bumble.VerifyBackup(bufio.NewReader(f))
+	essentials.Must(err)
+	log.Printf("restore: archive created %s with %d files", manifest.Created, len(manifest.Files))
+	_, err = f.Seek(0, io.SeekStart)
+	essentials.Must(err)
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	stats, err := bumble.RestoreBackup(ctx, db, bufio.NewReader(f))
+	essentials.Must(err)
+	log.Printf("restore: done: users %d added, %d skipped; locations %d added, %d skipped; "+
+		"photos %d added, %d skipped", stats.UsersAdded, stats.UsersSkipped,
+		stats.LocationsAdded, stats.LocationsSkipped, stats.PhotosAdded, stats.PhotosSkipped)
+}
@@ -0,0 +1,63 @@
+// Command rotate_photo_key re-encrypts every stored photo
+// with a new key.
+//
+// It can also encrypt an existing unencrypted photo store,
+// or decrypt an encrypted one with -decrypt. Photos which
+// are already encrypted with the new key are skipped, so
+// an interrupted rotation can simply be run again.
+//
+// Pack stores are compacted afterwards, so that the old
+// data does not remain in the pack files.
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var oldKeyFile, newKeyFile string
+	var decrypt bool
+	flag.StringVar(&oldKeyFile, "old-key", "",
+		"file containing the current key (default: BUMBLE_PHOTO_KEY_FILE or BUMBLE_PHOTO_KEY)")
+	flag.StringVar(&newKeyFile, "new-key", "", "file containing the new key")
+	flag.BoolVar(&decrypt, "decrypt", false, "decrypt every photo instead of using a new key")
+	flag.Parse()
+
+	if (newKeyFile == "") == !decrypt {
+		essentials.Die("Exactly one of -new-key or -decrypt is required. See -help.")
+	}
+
+	var oldKey, newKey []byte
+	if oldKeyFile != "" {
+		oldKey, err = bumble.LoadPhotoKey(oldKeyFile)
+	} else {
+		oldKey, err = config.PhotoEncryptionKey()
+	}
+	essentials.Must(err)
+	if newKeyFile != "" {
+		newKey, err = bumble.LoadPhotoKey(newKeyFile)
+		essentials.Must(err)
+	}
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	// The raw store is used so that the stored bytes can
+	// be read and written regardless of the current key.
+	store, err := bumble.OpenPhotoStore(config.PhotoLayout, config.PhotosPath)
+	essentials.Must(err)
+
+	stats, err := bumble.ReencryptPhotos(ctx, store, oldKey, newKey)
+	if stats != nil {
+		log.Printf("rotate_photo_key: rewrote %d photos, skipped %d", stats.Rewritten,
+			stats.Skipped)
+	}
+	essentials.Must(err)
+}
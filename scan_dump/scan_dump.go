@@ -1,11 +1,25 @@
 // Command scan_dump reads user profiles as JSON from
 // stardard input and inserts them into the database,
 // fetching profile pictures as needed.
+//
+// If a minimization policy is configured, the parts of
+// each user it does not allow are removed before the user
+// is stored, and photos are only downloaded if the policy
+// allows them. If a pseudonymization key is configured,
+// user and photo IDs are replaced with keyed hashes and
+// names are removed as well.
+//
+// Users are stored in batches, which are written once
+// they reach -batch-size users or after -flush-interval,
+// whichever comes first. Users that fail to be stored are
+// logged individually.
 package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"image"
 	_ "image/gif"
 	"image/jpeg"
@@ -16,6 +30,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/nfnt/resize"
 	"github.com/pkg/errors"
@@ -23,51 +38,210 @@ import (
 )
 
 const (
-	NumPhotoWorkers  = 8
-	MaxPhotosPerUser = 2
-	MaxImageSize     = 512
+	NumPhotoWorkers = 8
+	MaxImageSize    = 512
 )
 
 func main() {
-	db, err := bumble.OpenDatabase(bumble.GetConfig())
+	config, err := bumble.GetConfig()
 	if err != nil {
 		log.Fatalln("scan_dump:", err)
 	}
 
-	photoChan := make(chan *bumble.Photo, 16)
+	var batchSize int
+	var flushInterval time.Duration
+	flag.StringVar(&config.PseudonymKeyFile, "pseudonym-key", config.PseudonymKeyFile,
+		"pseudonymize users with the secret key in this file")
+	flag.BoolVar(&config.Redact, "redact", config.Redact,
+		"redact phone numbers, emails and handles from profiles")
+	flag.IntVar(&batchSize, "batch-size", 100, "maximum number of users to store at once")
+	flag.DurationVar(&flushInterval, "flush-interval", 5*time.Second,
+		"maximum time to hold users before storing them")
+	flag.Parse()
+
+	if batchSize < 1 || flushInterval <= 0 {
+		log.Fatalln("scan_dump: batch size and flush interval must be positive")
+	}
+
+	ingester, err := bumble.NewIngester(config)
+	if err != nil {
+		log.Fatalln("scan_dump:", err)
+	}
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	if err != nil {
+		log.Fatalln("scan_dump:", err)
+	}
+	defer db.Close()
+
+	photoChan := make(chan *photoJob, 16)
 	photoWg := sync.WaitGroup{}
 	for i := 0; i < NumPhotoWorkers; i++ {
 		photoWg.Add(1)
-		go photoDownloader(db, photoChan, &photoWg)
+		go photoDownloader(ctx, db, photoChan, &photoWg)
 	}
 	defer photoWg.Wait()
 	defer close(photoChan)
 
-	dec := json.NewDecoder(os.Stdin)
+	batch := &userBatch{DB: db, Photos: photoChan}
+	defer func() {
+		log.Printf("scan_dump: stored %d users (%d failed)", batch.NumStored, batch.NumFailed)
+	}()
+
+	// Users that were read before an interrupt are still
+	// stored, limited by the configured call timeout.
+	defer batch.Flush(context.Background())
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	users := readUsers(ctx, os.Stdin)
 	for {
-		var user bumble.User
-		if err := dec.Decode(&user); err != nil {
-			if errors.Cause(err) == io.EOF {
-				log.Println("scan_dump: read EOF")
+		select {
+		case <-ctx.Done():
+			log.Println("scan_dump: interrupted")
+			return
+		case <-ticker.C:
+			batch.Flush(ctx)
+		case item := <-users:
+			if item.Err != nil {
+				if errors.Cause(item.Err) == io.EOF {
+					log.Println("scan_dump: read EOF")
+					return
+				}
+				batch.Flush(ctx)
+				log.Fatalln("scan_dump:", item.Err)
+			}
+			user := item.User
+			photos := ingester.Ingest(user)
+			if len(photos) > config.MaxPhotosPerUser {
+				photos = photos[:config.MaxPhotosPerUser]
+			}
+			var jobs []*photoJob
+			for _, photo := range photos {
+				jobs = append(jobs, &photoJob{Photo: photo.Photo, URL: "https:" + photo.SourceURL})
+			}
+			batch.Add(user, jobs)
+			if len(batch.Users) >= batchSize {
+				batch.Flush(ctx)
+			}
+		}
+	}
+}
+
+// A decodedUser is a user read from the input, or the
+// error that ended the input.
+type decodedUser struct {
+	User *bumble.User
+	Err  error
+}
+
+// readUsers decodes users in the background, so that
+// batches can be flushed while waiting for input.
+func readUsers(ctx context.Context, r io.Reader) <-chan decodedUser {
+	res := make(chan decodedUser)
+	go func() {
+		dec := json.NewDecoder(r)
+		for {
+			var item decodedUser
+			var user bumble.User
+			if err := dec.Decode(&user); err != nil {
+				item.Err = err
+			} else {
+				item.User = &user
+			}
+			select {
+			case res <- item:
+			case <-ctx.Done():
+				return
+			}
+			if item.Err != nil {
 				return
 			}
-			log.Fatalln("scan_dump:", err)
 		}
-		db.AddUser(&user)
-		photos := user.AllPhotos()
-		if len(photos) > MaxPhotosPerUser {
-			photos = photos[:MaxPhotosPerUser]
+	}()
+	return res
+}
+
+// A userBatch collects users to store with AddUsers, along
+// with the photos to download for each user once it has
+// been stored.
+type userBatch struct {
+	DB     bumble.Database
+	Photos chan<- *photoJob
+
+	Users []*bumble.User
+	Jobs  [][]*photoJob
+
+	NumStored int
+	NumFailed int
+}
+
+func (b *userBatch) Add(user *bumble.User, jobs []*photoJob) {
+	b.Users = append(b.Users, user)
+	b.Jobs = append(b.Jobs, jobs)
+}
+
+// Flush stores the users in the batch, logging each user
+// that could not be stored, and queues the photos of the
+// stored users.
+func (b *userBatch) Flush(ctx context.Context) {
+	if len(b.Users) == 0 {
+		return
+	}
+	failed := map[int]bool{}
+	err := b.DB.AddUsers(ctx, b.Users)
+	if bulkErr, ok := errors.Cause(err).(*bumble.BulkError); ok {
+		for _, failure := range bulkErr.Failures {
+			log.Printf("scan_dump: failed to store user %s: %s", failure.UserID, failure.Err)
+			failed[failure.Index] = true
 		}
-		for _, photo := range photos {
-			photoChan <- photo
+	} else if err != nil {
+		for i, user := range b.Users {
+			log.Printf("scan_dump: failed to store user %s: %s", user.ID, err)
+			failed[i] = true
 		}
 	}
+	b.NumFailed += len(failed)
+	b.NumStored += len(b.Users) - len(failed)
+
+	for i, jobs := range b.Jobs {
+		if failed[i] {
+			continue
+		}
+		for _, job := range jobs {
+			select {
+			case b.Photos <- job:
+			case <-ctx.Done():
+			}
+		}
+	}
+	b.Users = nil
+	b.Jobs = nil
+}
+
+// A photoJob is a photo to download.
+type photoJob struct {
+	Photo *bumble.Photo
+	URL   string
 }
 
-func photoDownloader(db bumble.Database, ch <-chan *bumble.Photo, wg *sync.WaitGroup) {
+func photoDownloader(ctx context.Context, db bumble.Database, ch <-chan *photoJob,
+	wg *sync.WaitGroup) {
 	defer wg.Done()
-	for photo := range ch {
-		resp, err := http.Get("https:" + photo.LargeURL)
+	for job := range ch {
+		if ctx.Err() != nil {
+			continue
+		}
+		req, err := http.NewRequest("GET", job.URL, nil)
+		if err != nil {
+			log.Println("scan_dump:", err)
+			continue
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 		if err != nil {
 			log.Println("scan_dump:", err)
 			continue
@@ -83,7 +257,7 @@ func photoDownloader(db bumble.Database, ch <-chan *bumble.Photo, wg *sync.WaitG
 			log.Println("scan_dump:", err)
 			continue
 		}
-		if err := db.AddPhoto(photo, data); err != nil {
+		if err := db.AddPhoto(ctx, job.Photo, data); err != nil {
 			log.Println("scan_dump:", err)
 		}
 	}
@@ -5,7 +5,6 @@ package main
 import (
 	"context"
 	"log"
-	"time"
 
 	"github.com/unixpickle/bumble-dump"
 	"github.com/unixpickle/essentials"
@@ -15,21 +14,32 @@ import (
 )
 
 func main() {
-	config := bumble.GetConfig()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+	dbName, err := config.MongoDatabaseName()
+	essentials.Must(err)
+	names := config.CollectionNames()
+
+	ctx, cancel := bumble.InterruptContext()
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.DatabaseURI))
+
+	connectCtx, connectCancel := config.ConnectContext(ctx)
+	defer connectCancel()
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(config.DatabaseURI))
 	essentials.Must(err)
-	db := client.Database("bumble")
+	defer client.Disconnect(context.Background())
+	db := client.Database(dbName)
 
 	log.Println("Creating indices...")
-	createUniqueID(db.Collection("profiles"))
-	createUniqueID(db.Collection("photos"))
-	createLocationIndex(db.Collection("profiles"))
+	createUniqueID(ctx, db.Collection(names.Profiles))
+	createUniqueID(ctx, db.Collection(names.Photos))
+	createLocationIndex(ctx, db.Collection(names.Profiles))
+	createHistoryIndex(ctx, db.Collection(names.History))
+	createGeoIndex(ctx, db.Collection(names.Locations))
 }
 
-func createUniqueID(coll *mongo.Collection) {
-	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+func createUniqueID(ctx context.Context, coll *mongo.Collection) {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys:    bson.D{{Key: "id", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}, nil)
@@ -38,11 +48,51 @@ func createUniqueID(coll *mongo.Collection) {
 	}
 }
 
-func createLocationIndex(coll *mongo.Collection) {
-	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+func createLocationIndex(ctx context.Context, coll *mongo.Collection) {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.D{{Key: "location", Value: 1}},
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// createHistoryIndex creates a unique index on the ID and
+// scan date of history versions, so that concurrent
+// writers cannot store the same version twice.
+//
+// Databases set up by older versions have a non-unique
+// index on the same keys, which is replaced.
+func createHistoryIndex(ctx context.Context, coll *mongo.Collection) {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "scandate", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := coll.Indexes().CreateOne(ctx, model)
+	if cmdErr, ok := err.(mongo.CommandError); ok && isIndexConflict(cmdErr) {
+		log.Println("Replacing non-unique history index...")
+		_, err = coll.Indexes().DropOne(ctx, "id_1_scandate_1")
+		if err == nil {
+			_, err = coll.Indexes().CreateOne(ctx, model)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isIndexConflict checks if an index could not be created
+// because an index with the same name or keys but
+// different options already exists.
+func isIndexConflict(err mongo.CommandError) bool {
+	return err.Code == 85 || err.Code == 86
+}
+
+func createGeoIndex(ctx context.Context, coll *mongo.Collection) {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "point", Value: "2dsphere"}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
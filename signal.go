@@ -0,0 +1,30 @@
+package bumble
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// InterruptContext creates a context which is canceled
+// when the process receives SIGINT or SIGTERM, so that a
+// command can stop its database operations and exit
+// cleanly.
+//
+// Only the first signal is caught. A second signal kills
+// the process as usual.
+func InterruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,599 @@
+package bumble
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const sqliteScheme = "sqlite://"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS profiles (
+	id       TEXT PRIMARY KEY,
+	location TEXT NOT NULL,
+	data     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS profiles_location ON profiles (location);
+CREATE TABLE IF NOT EXISTS profile_history (
+	id        TEXT NOT NULL,
+	scan_date INTEGER NOT NULL,
+	data      TEXT NOT NULL,
+	PRIMARY KEY (id, scan_date)
+);
+CREATE TABLE IF NOT EXISTS photos (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS pending_photos (
+	id      TEXT PRIMARY KEY,
+	started INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS locations (
+	name         TEXT PRIMARY KEY,
+	lat          REAL NOT NULL,
+	lon          REAL NOT NULL,
+	country_code TEXT NOT NULL
+);
+`
+
+// sqliteDatabase is a Database stored in a single SQLite
+// file.
+//
+// Users and photo metadata are stored as JSON, with the
+// columns needed for lookups duplicated next to them.
+type sqliteDatabase struct {
+	config *Config
+	store  PhotoStore
+	db     *sql.DB
+}
+
+func openSQLiteDatabase(c *Config) (Database, error) {
+	store, err := OpenConfigPhotoStore(c)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimPrefix(c.DatabaseURI, sqliteScheme)
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=10000")
+	if err != nil {
+		return nil, errors.Wrap(err, "open sqlite database")
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "open sqlite database")
+	}
+	res := &sqliteDatabase{config: c, store: store, db: db}
+	ctx, cancel := c.callContext(context.Background())
+	defer cancel()
+	if err := recoverPhotos(ctx, res, store); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "open sqlite database")
+	}
+	return res, nil
+}
+
+func (s *sqliteDatabase) AddUser(ctx context.Context, u *User) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "add user")
+	}
+	defer tx.Rollback()
+	if err := s.putUser(ctx, tx, u); err != nil {
+		return errors.Wrap(err, "add user")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "add user")
+	}
+	return nil
+}
+
+// AddUsers adds a batch of users in one transaction.
+//
+// Each user is written inside a savepoint, so a user that
+// fails to be written is rolled back without affecting
+// the rest of the batch.
+func (s *sqliteDatabase) AddUsers(ctx context.Context, users []*User) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "add users")
+	}
+	defer tx.Rollback()
+
+	var failures []*BulkFailure
+	for i, u := range users {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT add_user"); err != nil {
+			return errors.Wrap(err, "add users")
+		}
+		if err := s.putUser(ctx, tx, u); err != nil {
+			if ctx.Err() != nil {
+				return errors.Wrap(err, "add users")
+			}
+			failures = append(failures, &BulkFailure{Index: i, UserID: u.ID, Err: err})
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO add_user"); err != nil {
+				return errors.Wrap(err, "add users")
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE add_user"); err != nil {
+			return errors.Wrap(err, "add users")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "add users")
+	}
+	return newBulkError(failures)
+}
+
+// putUser writes a user and, if KeepHistory is enabled,
+// its version in the history.
+func (s *sqliteDatabase) putUser(ctx context.Context, tx *sql.Tx, u *User) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if s.config.KeepHistory {
+		if err := s.addUserVersion(ctx, tx, u, data); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO profiles (id, location, data) "+
+		"VALUES (?, ?, ?)", u.ID, u.Location, string(data))
+	return err
+}
+
+func (s *sqliteDatabase) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	if _, err := DeleteUserPhotos(ctx, s, userID); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM profile_history WHERE id = ?", userID); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM profiles WHERE id = ?", userID); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+	return nil
+}
+
+func (s *sqliteDatabase) DeleteHistory(ctx context.Context, userID string,
+	before time.Time) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, "DELETE FROM profile_history WHERE id = ? AND scan_date < ?",
+		userID, before.UnixNano())
+	if err != nil {
+		return errors.Wrap(err, "delete history")
+	}
+	return nil
+}
+
+func (s *sqliteDatabase) RewriteUser(ctx context.Context, userID string, f func(u *User)) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT scan_date, data FROM profile_history WHERE id = ?",
+		userID)
+	if err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	versions := map[int64]string{}
+	for rows.Next() {
+		var scanDate int64
+		var data string
+		if err := rows.Scan(&scanDate, &data); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "rewrite user")
+		}
+		versions[scanDate] = data
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	for scanDate, data := range versions {
+		newData, err := rewriteUserData(data, f)
+		if err != nil {
+			return errors.Wrap(err, "rewrite user")
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE profile_history SET data = ? WHERE id = ? AND scan_date = ?",
+			newData, userID, scanDate)
+		if err != nil {
+			return errors.Wrap(err, "rewrite user")
+		}
+	}
+
+	var data string
+	err = tx.QueryRowContext(ctx, "SELECT data FROM profiles WHERE id = ?", userID).Scan(&data)
+	if err == nil {
+		var u User
+		if err := json.Unmarshal([]byte(data), &u); err != nil {
+			return errors.Wrap(err, "rewrite user")
+		}
+		f(&u)
+		newData, err := json.Marshal(&u)
+		if err != nil {
+			return errors.Wrap(err, "rewrite user")
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE profiles SET location = ?, data = ? WHERE id = ?",
+			u.Location, string(newData), userID)
+		if err != nil {
+			return errors.Wrap(err, "rewrite user")
+		}
+	} else if err != sql.ErrNoRows {
+		return errors.Wrap(err, "rewrite user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "rewrite user")
+	}
+	return nil
+}
+
+func rewriteUserData(data string, f func(u *User)) (string, error) {
+	var u User
+	if err := json.Unmarshal([]byte(data), &u); err != nil {
+		return "", err
+	}
+	f(&u)
+	newData, err := json.Marshal(&u)
+	return string(newData), err
+}
+
+func (s *sqliteDatabase) addUserVersion(ctx context.Context, tx *sql.Tx, u *User,
+	data []byte) error {
+	var prevData string
+	err := tx.QueryRowContext(ctx, "SELECT data FROM profile_history "+
+		"WHERE id = ? AND scan_date <= ? ORDER BY scan_date DESC LIMIT 1", u.ID,
+		u.ScanDate.UnixNano()).Scan(&prevData)
+	if err == nil {
+		var prev User
+		if err := json.Unmarshal([]byte(prevData), &prev); err != nil {
+			return err
+		}
+		if prev.SameProfile(u) {
+			return nil
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO profile_history (id, scan_date, data) "+
+		"VALUES (?, ?, ?)", u.ID, u.ScanDate.UnixNano(), string(data))
+	return err
+}
+
+func (s *sqliteDatabase) GetUser(ctx context.Context, userID string) (*User, error) {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM profiles WHERE id = ?", userID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrap(ErrUserNotFound, "get user")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "get user")
+	}
+	var user User
+	if err := json.Unmarshal([]byte(data), &user); err != nil {
+		return nil, errors.Wrap(err, "get user")
+	}
+	return &user, nil
+}
+
+func (s *sqliteDatabase) UserHistory(ctx context.Context, userID string) *UserIterator {
+	if !s.config.KeepHistory {
+		return s.users(ctx, "SELECT data FROM profiles WHERE id = ?", userID)
+	}
+	return s.users(ctx, "SELECT data FROM profile_history WHERE id = ? ORDER BY scan_date",
+		userID)
+}
+
+func (s *sqliteDatabase) users(ctx context.Context, query string,
+	args ...interface{}) *UserIterator {
+	return newUserIterator(ctx, func(ctx context.Context, send func(u *User) bool) error {
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var data string
+			if err := rows.Scan(&data); err != nil {
+				return err
+			}
+			var u *User
+			if err := json.Unmarshal([]byte(data), &u); err != nil {
+				return err
+			}
+			if !send(u) {
+				return nil
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func (s *sqliteDatabase) AllUsers(ctx context.Context) *UserIterator {
+	return s.users(ctx, "SELECT data FROM profiles")
+}
+
+func (s *sqliteDatabase) Users(ctx context.Context, filter *UserFilter) *UserIterator {
+	cond, args := filter.sqlCondition()
+	return s.users(ctx, "SELECT data FROM profiles WHERE "+cond+" ORDER BY profiles.id", args...)
+}
+
+func (s *sqliteDatabase) UsersAt(ctx context.Context, location string) *UserIterator {
+	return s.users(ctx, "SELECT data FROM profiles WHERE location = ?", location)
+}
+
+func (s *sqliteDatabase) AllUserLocations(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT location FROM profiles")
+	if err != nil {
+		return nil, errors.Wrap(err, "all user locations")
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var loc string
+		if err := rows.Scan(&loc); err != nil {
+			return nil, errors.Wrap(err, "all user locations")
+		}
+		res = append(res, loc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "all user locations")
+	}
+	return res, nil
+}
+
+func (s *sqliteDatabase) UsersNear(ctx context.Context, lat, lon,
+	maxDist float64) *UserIterator {
+	names, err := locationNamesNear(ctx, s, lat, lon, maxDist)
+	if err != nil {
+		return errorUserIterator(errors.Wrap(err, "get users near"))
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	return s.users(ctx, "SELECT data FROM profiles WHERE location IN ("+
+		sqlPlaceholders(len(names))+")", args...)
+}
+
+func (s *sqliteDatabase) PhotoExists(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM photos WHERE id = ?", id).Scan(&count)
+	if err != nil {
+		return false, errors.Wrap(err, "check photo exists")
+	}
+	return count > 0, nil
+}
+
+func (s *sqliteDatabase) AddPhoto(ctx context.Context, photo *Photo, data []byte) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	if err := addPhotoTwoPhase(ctx, s, s.store, photo, data); err != nil {
+		return errors.Wrap(err, "add photo")
+	}
+	return nil
+}
+
+func (s *sqliteDatabase) beginPhoto(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO pending_photos (id, started) "+
+		"VALUES (?, ?)", id, time.Now().UnixNano())
+	return err
+}
+
+func (s *sqliteDatabase) commitPhoto(ctx context.Context, photo *Photo) error {
+	metadata, err := json.Marshal(photo)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO photos (id, data) VALUES (?, ?)",
+		photo.ID, string(metadata))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM pending_photos WHERE id = ?", photo.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteDatabase) endPhoto(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM pending_photos WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteDatabase) pendingPhotos(ctx context.Context, cutoff time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM pending_photos WHERE started < ?",
+		cutoff.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqliteDatabase) DeletePhoto(ctx context.Context, id string) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM photos WHERE id = ?", id); err != nil {
+		return errors.Wrap(err, "delete photo")
+	}
+	if err := s.store.DeletePhoto(id); err != nil {
+		return errors.Wrap(err, "delete photo")
+	}
+	return nil
+}
+
+func (s *sqliteDatabase) GetPhoto(ctx context.Context, id string) (*Photo, []byte, error) {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	var metadata string
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM photos WHERE id = ?", id).Scan(&metadata)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get photo")
+	}
+	var photo Photo
+	if err := json.Unmarshal([]byte(metadata), &photo); err != nil {
+		return nil, nil, errors.Wrap(err, "get photo")
+	}
+	data, err := s.store.ReadPhoto(id)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get photo")
+	}
+	return &photo, data, nil
+}
+
+func (s *sqliteDatabase) AddLocation(ctx context.Context, loc *Location) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO locations (name, lat, lon, country_code) "+
+		"VALUES (?, ?, ?, ?)", loc.Name, loc.Lat, loc.Lon, loc.CountryCode)
+	if err != nil {
+		return errors.Wrap(err, "add location")
+	}
+	return nil
+}
+
+func (s *sqliteDatabase) GetLocation(ctx context.Context, name string) (*Location, error) {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	var loc Location
+	err := s.db.QueryRowContext(ctx,
+		"SELECT name, lat, lon, country_code FROM locations WHERE name = ?", name).Scan(
+		&loc.Name, &loc.Lat, &loc.Lon, &loc.CountryCode)
+	if err != nil {
+		return nil, errors.Wrap(err, "get location")
+	}
+	loc.Point = NewGeoPoint(loc.Lat, loc.Lon)
+	return &loc, nil
+}
+
+func (s *sqliteDatabase) AllPhotos(ctx context.Context) *PhotoIterator {
+	return newPhotoIterator(ctx, func(ctx context.Context, send func(p *Photo) bool) error {
+		rows, err := s.db.QueryContext(ctx, "SELECT data FROM photos")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var data string
+			if err := rows.Scan(&data); err != nil {
+				return err
+			}
+			var p Photo
+			if err := json.Unmarshal([]byte(data), &p); err != nil {
+				return err
+			}
+			if !send(&p) {
+				return nil
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func (s *sqliteDatabase) AllLocations(ctx context.Context) *LocationIterator {
+	return newLocationIterator(ctx, func(ctx context.Context, send func(l *Location) bool) error {
+		rows, err := s.db.QueryContext(ctx, "SELECT name, lat, lon, country_code FROM locations")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var l Location
+			if err := rows.Scan(&l.Name, &l.Lat, &l.Lon, &l.CountryCode); err != nil {
+				return err
+			}
+			l.Point = NewGeoPoint(l.Lat, l.Lon)
+			if !send(&l) {
+				return nil
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func (s *sqliteDatabase) LocationsNear(ctx context.Context, lat, lon,
+	maxDist float64) *LocationIterator {
+	return locationsNear(ctx, s, lat, lon, maxDist)
+}
+
+func (s *sqliteDatabase) SchemaVersion(ctx context.Context) (int, error) {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "get schema version")
+	}
+	return version, nil
+}
+
+func (s *sqliteDatabase) SetSchemaVersion(ctx context.Context, version int) error {
+	ctx, cancel := s.config.callContext(ctx)
+	defer cancel()
+	// PRAGMA statements cannot use query parameters.
+	_, err := s.db.ExecContext(ctx, "PRAGMA user_version = "+strconv.Itoa(version))
+	if err != nil {
+		return errors.Wrap(err, "set schema version")
+	}
+	return nil
+}
+
+func (s *sqliteDatabase) photoStore() PhotoStore {
+	return s.store
+}
+
+func (s *sqliteDatabase) Close() error {
+	if err := s.db.Close(); err != nil {
+		return errors.Wrap(err, "close database")
+	}
+	return nil
+}
+
+// sqlPlaceholders creates a comma-separated list of n
+// query placeholders.
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
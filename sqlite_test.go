@@ -0,0 +1,52 @@
+package bumble
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteDeleteUserUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(&Config{
+		DatabaseURI: "sqlite://" + filepath.Join(dir, "db.sqlite"),
+		PhotosPath:  filepath.Join(dir, "photos"),
+		KeepHistory: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.AddUser(ctx, &User{ID: "user1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// If the profile cannot be read, its photos cannot be
+	// found, so the user must not be deleted.
+	_, err = db.(*sqliteDatabase).db.Exec("UPDATE profiles SET data = 'invalid' WHERE id = 'user1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteUser(ctx, "user1"); err == nil {
+		t.Fatal("expected error deleting unreadable user")
+	}
+	versions, err := collectHistory(ctx, db, "user1")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(versions) != 1 {
+		t.Errorf("expected history to be kept but got %d versions", len(versions))
+	}
+
+	if err := db.DeleteUser(ctx, "missing"); err != nil {
+		t.Errorf("deleting missing user: %s", err)
+	}
+}
@@ -0,0 +1,198 @@
+package bumble
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AgeBucketSize is the width, in years, of each bucket in
+// the age histogram of Stats.
+const AgeBucketSize = 5
+
+// Stats summarizes the contents of a database.
+type Stats struct {
+	NumUsers int `json:"num_users"`
+
+	// Genders maps each gender to its number of users.
+	Genders map[int]int `json:"genders"`
+
+	// AgeHistogram counts users in buckets of ages, sorted
+	// by age. Empty buckets are omitted.
+	AgeHistogram []*AgeBucket `json:"age_histogram"`
+
+	NumVerified      int     `json:"num_verified"`
+	VerifiedFraction float64 `json:"verified_fraction"`
+
+	// NumLocations is the number of distinct Location
+	// values among users, and NumGeocoded is the number of
+	// these which have been geocoded.
+	NumLocations     int     `json:"num_locations"`
+	NumGeocoded      int     `json:"num_geocoded"`
+	GeocodedFraction float64 `json:"geocoded_fraction"`
+
+	Photos *PhotoStats `json:"photos"`
+
+	// ProfileFields maps each ProfileField ID to the
+	// number of users with that field.
+	ProfileFields map[string]int `json:"profile_fields"`
+
+	// FirstScan and LastScan are the range of ScanDates.
+	// They are zero if there are no users.
+	FirstScan time.Time `json:"first_scan"`
+	LastScan  time.Time `json:"last_scan"`
+}
+
+// An AgeBucket counts the users with ages in the range
+// [MinAge, MaxAge].
+type AgeBucket struct {
+	MinAge int `json:"min_age"`
+	MaxAge int `json:"max_age"`
+	Count  int `json:"count"`
+}
+
+// PhotoStats describes how many photos are stored, compared
+// to the number that would be downloaded if every user had
+// their first MaxPhotosPerUser photos stored.
+type PhotoStats struct {
+	MaxPhotosPerUser int `json:"max_photos_per_user"`
+
+	// NumStored is the number of photos stored in the
+	// database, including photos of users who no longer
+	// reference them.
+	NumStored int `json:"num_stored"`
+
+	// NumExpected and NumPresent count the photos which
+	// should be downloaded, and how many of them are.
+	NumExpected     int     `json:"num_expected"`
+	NumPresent      int     `json:"num_present"`
+	PresentFraction float64 `json:"present_fraction"`
+
+	// UsersComplete counts the users whose expected photos
+	// are all present, and UsersNone counts the users who
+	// have expected photos but none of them are present.
+	UsersComplete int `json:"users_complete"`
+	UsersNone     int `json:"users_none"`
+}
+
+// ComputeStats reads every user in a database and
+// summarizes them.
+//
+// The maxPhotos argument is typically the
+// MaxPhotosPerUser of the database's Config.
+func ComputeStats(ctx context.Context, db Database, maxPhotos int) (*Stats, error) {
+	storedPhotos := map[string]bool{}
+	photos := db.AllPhotos(ctx)
+	defer photos.Close()
+	for photos.Next() {
+		photo := photos.Value()
+		storedPhotos[photo.ID] = true
+	}
+	if err := photos.Err(); err != nil {
+		return nil, errors.Wrap(err, "compute stats")
+	}
+
+	geocoded := map[string]bool{}
+	locs := db.AllLocations(ctx)
+	defer locs.Close()
+	for locs.Next() {
+		loc := locs.Value()
+		geocoded[loc.Name] = true
+	}
+	if err := locs.Err(); err != nil {
+		return nil, errors.Wrap(err, "compute stats")
+	}
+
+	stats := &Stats{
+		Genders:       map[int]int{},
+		ProfileFields: map[string]int{},
+		Photos: &PhotoStats{
+			MaxPhotosPerUser: maxPhotos,
+			NumStored:        len(storedPhotos),
+		},
+	}
+	ageCounts := map[int]int{}
+	locations := map[string]bool{}
+
+	users := db.AllUsers(ctx)
+	defer users.Close()
+	for users.Next() {
+		u := users.Value()
+		stats.NumUsers++
+		stats.Genders[u.Gender]++
+		ageCounts[u.Age/AgeBucketSize]++
+		if u.Verified {
+			stats.NumVerified++
+		}
+		locations[u.Location] = true
+		if stats.FirstScan.IsZero() || u.ScanDate.Before(stats.FirstScan) {
+			stats.FirstScan = u.ScanDate
+		}
+		if u.ScanDate.After(stats.LastScan) {
+			stats.LastScan = u.ScanDate
+		}
+
+		fieldIDs := map[string]bool{}
+		for _, field := range u.ProfileFields {
+			fieldIDs[field.ID] = true
+		}
+		for id := range fieldIDs {
+			stats.ProfileFields[id]++
+		}
+
+		photos := u.AllPhotos()
+		if len(photos) > maxPhotos {
+			photos = photos[:maxPhotos]
+		}
+		var numPresent int
+		for _, photo := range photos {
+			if storedPhotos[photo.ID] {
+				numPresent++
+			}
+		}
+		stats.Photos.NumExpected += len(photos)
+		stats.Photos.NumPresent += numPresent
+		if numPresent == len(photos) {
+			stats.Photos.UsersComplete++
+		}
+		if numPresent == 0 && len(photos) > 0 {
+			stats.Photos.UsersNone++
+		}
+	}
+	if err := users.Err(); err != nil {
+		return nil, errors.Wrap(err, "compute stats")
+	}
+
+	for bucket, count := range ageCounts {
+		stats.AgeHistogram = append(stats.AgeHistogram, &AgeBucket{
+			MinAge: bucket * AgeBucketSize,
+			MaxAge: (bucket+1)*AgeBucketSize - 1,
+			Count:  count,
+		})
+	}
+	sort.Slice(stats.AgeHistogram, func(i, j int) bool {
+		return stats.AgeHistogram[i].MinAge < stats.AgeHistogram[j].MinAge
+	})
+
+	stats.NumLocations = len(locations)
+	for loc := range locations {
+		if geocoded[loc] {
+			stats.NumGeocoded++
+		}
+	}
+
+	stats.VerifiedFraction = fraction(stats.NumVerified, stats.NumUsers)
+	stats.GeocodedFraction = fraction(stats.NumGeocoded, stats.NumLocations)
+	stats.Photos.PresentFraction = fraction(stats.Photos.NumPresent, stats.Photos.NumExpected)
+
+	return stats, nil
+}
+
+func fraction(num, denom int) float64 {
+	if denom == 0 {
+		return 0
+	}
+	return float64(num) / float64(denom)
+}
@@ -0,0 +1,98 @@
+// Command stats prints a summary of the users, locations
+// and photos in the database.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/unixpickle/bumble-dump"
+	"github.com/unixpickle/essentials"
+)
+
+func main() {
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var jsonOutput bool
+	flag.BoolVar(&jsonOutput, "json", false, "print the statistics as JSON")
+	flag.Parse()
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
+	essentials.Must(err)
+	defer db.Close()
+
+	stats, err := bumble.ComputeStats(ctx, db, config.MaxPhotosPerUser)
+	essentials.Must(err)
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		essentials.Must(enc.Encode(stats))
+	} else {
+		printStats(stats)
+	}
+}
+
+func printStats(s *bumble.Stats) {
+	fmt.Println("Users:", s.NumUsers)
+	if s.NumUsers > 0 {
+		fmt.Printf("Scan dates: %s to %s\n", s.FirstScan.Format(time.RFC3339),
+			s.LastScan.Format(time.RFC3339))
+	}
+	fmt.Printf("Verified: %d (%.1f%%)\n", s.NumVerified, 100*s.VerifiedFraction)
+
+	fmt.Println()
+	fmt.Println("Genders:")
+	var genders []int
+	for gender := range s.Genders {
+		genders = append(genders, gender)
+	}
+	sort.Ints(genders)
+	for _, gender := range genders {
+		fmt.Printf("  %d: %d\n", gender, s.Genders[gender])
+	}
+
+	fmt.Println()
+	fmt.Println("Ages:")
+	for _, bucket := range s.AgeHistogram {
+		fmt.Printf("  %d-%d: %d\n", bucket.MinAge, bucket.MaxAge, bucket.Count)
+	}
+
+	fmt.Println()
+	fmt.Printf("Locations: %d (%d geocoded, %.1f%%)\n", s.NumLocations, s.NumGeocoded,
+		100*s.GeocodedFraction)
+
+	p := s.Photos
+	fmt.Println()
+	fmt.Printf("Photos (up to %d per user):\n", p.MaxPhotosPerUser)
+	fmt.Printf("  stored: %d\n", p.NumStored)
+	fmt.Printf("  present: %d of %d (%.1f%%)\n", p.NumPresent, p.NumExpected,
+		100*p.PresentFraction)
+	fmt.Printf("  users with all photos: %d\n", p.UsersComplete)
+	fmt.Printf("  users with no photos: %d\n", p.UsersNone)
+
+	fmt.Println()
+	fmt.Println("Profile fields:")
+	var fieldIDs []string
+	for id := range s.ProfileFields {
+		fieldIDs = append(fieldIDs, id)
+	}
+	sort.Slice(fieldIDs, func(i, j int) bool {
+		c1, c2 := s.ProfileFields[fieldIDs[i]], s.ProfileFields[fieldIDs[j]]
+		if c1 == c2 {
+			return fieldIDs[i] < fieldIDs[j]
+		}
+		return c1 > c2
+	})
+	for _, id := range fieldIDs {
+		fmt.Printf("  %s: %d\n", id, s.ProfileFields[id])
+	}
+}
@@ -0,0 +1,82 @@
+package bumble
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	db, err := OpenDatabase(&Config{DatabaseURI: "memory://"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	date1 := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	date2 := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	photos := func(ids ...string) []*Album {
+		album := &Album{UID: "album"}
+		for _, id := range ids {
+			album.Photos = append(album.Photos, &Photo{ID: id})
+		}
+		return []*Album{album}
+	}
+	users := []*User{
+		{ID: "1", Age: 22, Gender: 1, Verified: true, Location: "A", ScanDate: date2,
+			Albums:        photos("p1", "p2", "p3"),
+			ProfileFields: []*ProfileField{{ID: "location"}, {ID: "aboutme_text"}}},
+		{ID: "2", Age: 24, Gender: 2, Location: "A", ScanDate: date1,
+			Albums:        photos("p4", "p5"),
+			ProfileFields: []*ProfileField{{ID: "location"}}},
+		{ID: "3", Age: 31, Gender: 2, Location: "B", ScanDate: date1,
+			Albums: photos("p6")},
+		{ID: "4", Age: 40, Gender: 2, Verified: true, Location: "C", ScanDate: date2},
+	}
+	for _, u := range users {
+		if err := db.AddUser(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"p1", "p2", "p4", "p7"} {
+		if err := db.AddPhoto(context.Background(), &Photo{ID: id}, []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.AddLocation(context.Background(), &Location{Name: "A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ComputeStats(context.Background(), db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Stats{
+		NumUsers: 4,
+		Genders:  map[int]int{1: 1, 2: 3},
+		AgeHistogram: []*AgeBucket{
+			{MinAge: 20, MaxAge: 24, Count: 2},
+			{MinAge: 30, MaxAge: 34, Count: 1},
+			{MinAge: 40, MaxAge: 44, Count: 1},
+		},
+		NumVerified:      2,
+		VerifiedFraction: 0.5,
+		NumLocations:     3,
+		NumGeocoded:      1,
+		GeocodedFraction: 1.0 / 3,
+		Photos: &PhotoStats{
+			MaxPhotosPerUser: 2,
+			NumStored:        4,
+			NumExpected:      5,
+			NumPresent:       3,
+			PresentFraction:  0.6,
+			UsersComplete:    2,
+			UsersNone:        1,
+		},
+		ProfileFields: map[string]int{"location": 2, "aboutme_text": 1},
+		FirstScan:     date1,
+		LastScan:      date2,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+}
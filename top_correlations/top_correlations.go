@@ -1,40 +1,63 @@
 // Command top_correlations computes word correlations.
+//
+// Flags can restrict the analysis to a subset of users,
+// in which case the correlations are computed only within
+// that subset.
 package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"strconv"
-	"strings"
 
 	"github.com/unixpickle/bumble-dump"
 	"github.com/unixpickle/essentials"
 )
 
+var population bumble.UserFilter
+
 func main() {
-	db, err := bumble.OpenDatabase(bumble.GetConfig())
+	config, err := bumble.GetConfig()
+	essentials.Must(err)
+
+	var redact bool
+	population.AddFlags(flag.CommandLine)
+	flag.BoolVar(&redact, "redact", false,
+		"redact phone numbers, emails and handles before counting words")
+	flag.Parse()
+
+	ctx, cancel := bumble.InterruptContext()
+	defer cancel()
+
+	db, err := bumble.OpenDatabase(config)
 	essentials.Must(err)
+	defer db.Close()
+	if redact {
+		db = bumble.RedactDatabase(db)
+	}
 
-	doCountry(db, "us")
-	doGender(db, "Male", 1)
-	doGender(db, "Female", 2)
-	doUnder24(db)
-	doOver40(db)
-	doOverSixFoot(db)
-	doZodiacSigns(db)
+	doCountry(ctx, db, "us")
+	doGender(ctx, db, "Male", 1)
+	doGender(ctx, db, "Female", 2)
+	doUnder24(ctx, db)
+	doOver40(ctx, db)
+	doOverSixFoot(ctx, db)
+	doZodiacSigns(ctx, db)
 }
 
-func doCountry(db bumble.Database, countryCode string) {
+func doCountry(ctx context.Context, db bumble.Database, countryCode string) {
 	fmt.Println("Country =", countryCode, "correlations:")
 	countryLocs := map[string]bool{}
-	locs, errCh := db.AllLocations(context.Background())
-	for loc := range locs {
+	locs := db.AllLocations(ctx)
+	defer locs.Close()
+	for locs.Next() {
+		loc := locs.Value()
 		if loc.CountryCode == countryCode {
 			countryLocs[loc.Name] = true
 		}
 	}
-	essentials.Must(<-errCh)
-	correlations, err := bumble.WordCorrelations(context.Background(), db,
+	essentials.Must(locs.Err())
+	correlations, err := bumble.FilteredWordCorrelations(ctx, db, &population,
 		func(u *bumble.User) bool {
 			return countryLocs[u.Location]
 		})
@@ -42,9 +65,9 @@ func doCountry(db bumble.Database, countryCode string) {
 	printTopCorrelations(correlations)
 }
 
-func doGender(db bumble.Database, genderStr string, genderNum int) {
+func doGender(ctx context.Context, db bumble.Database, genderStr string, genderNum int) {
 	fmt.Println("Gender =", genderStr, "correlations:")
-	correlations, err := bumble.WordCorrelations(context.Background(), db,
+	correlations, err := bumble.FilteredWordCorrelations(ctx, db, &population,
 		func(u *bumble.User) bool {
 			return u.Gender == genderNum
 		})
@@ -52,9 +75,9 @@ func doGender(db bumble.Database, genderStr string, genderNum int) {
 	printTopCorrelations(correlations)
 }
 
-func doUnder24(db bumble.Database) {
+func doUnder24(ctx context.Context, db bumble.Database) {
 	fmt.Println("Age < 24 correlations:")
-	correlations, err := bumble.WordCorrelations(context.Background(), db,
+	correlations, err := bumble.FilteredWordCorrelations(ctx, db, &population,
 		func(u *bumble.User) bool {
 			return u.Age < 24
 		})
@@ -62,9 +85,9 @@ func doUnder24(db bumble.Database) {
 	printTopCorrelations(correlations)
 }
 
-func doOver40(db bumble.Database) {
+func doOver40(ctx context.Context, db bumble.Database) {
 	fmt.Println("Age >= 40 correlations:")
-	correlations, err := bumble.WordCorrelations(context.Background(), db,
+	correlations, err := bumble.FilteredWordCorrelations(ctx, db, &population,
 		func(u *bumble.User) bool {
 			return u.Age >= 40
 		})
@@ -72,39 +95,21 @@ func doOver40(db bumble.Database) {
 	printTopCorrelations(correlations)
 }
 
-func doOverSixFoot(db bumble.Database) {
+func doOverSixFoot(ctx context.Context, db bumble.Database) {
 	fmt.Println("Height > 6ft correlations:")
-	correlations, err := bumble.WordCorrelations(context.Background(), db,
+	correlations, err := bumble.FilteredWordCorrelations(ctx, db, &population,
 		func(u *bumble.User) bool {
-			for _, field := range u.ProfileFields {
-				if field.ID == "lifestyle_height" {
-					heightCm := 0
-					fields := strings.Fields(strings.Replace(field.DisplayValue, "(", "", -1))
-					for i, f := range fields[1:] {
-						if f == "cm" {
-							heightCm, _ = strconv.Atoi(fields[i])
-							break
-						}
-					}
-					return heightCm >= 183
-				}
-			}
-			return false
+			return u.Attributes().HeightCM >= 183
 		})
 	essentials.Must(err)
 	printTopCorrelations(correlations)
 }
 
-func doZodiacSigns(db bumble.Database) {
+func doZodiacSigns(ctx context.Context, db bumble.Database) {
 	fmt.Println("Zodiac sign correlations:")
-	correlations, err := bumble.WordCorrelations(context.Background(), db,
+	correlations, err := bumble.FilteredWordCorrelations(ctx, db, &population,
 		func(u *bumble.User) bool {
-			for _, field := range u.ProfileFields {
-				if field.ID == "lifestyle_zodiak" {
-					return true
-				}
-			}
-			return false
+			return u.Attributes().Zodiac != ""
 		})
 	essentials.Must(err)
 	printTopCorrelations(correlations)